@@ -0,0 +1,387 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package exec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+)
+
+const (
+	CgroupV1 = 1
+	CgroupV2 = 2
+)
+
+// CgroupUnlimited is returned as the limit of cgroup v2 files whose value is `max`
+const CgroupUnlimited = uint64(math.MaxInt64)
+
+// Cgroup is the cgroup of a process, it hides the difference between
+// the cgroup v1 (legacy/hybrid) and v2 (unified) hierarchy.
+type Cgroup struct {
+	version int
+	root    string
+	// paths maps the v1 controller name to the cgroup path of the process,
+	// the v2 path is stored with the empty key
+	paths map[string]string
+}
+
+// CPUStat contains the cpu accounting of a cgroup, all times are nanoseconds
+type CPUStat struct {
+	Usage         uint64
+	NrPeriods     uint64
+	NrThrottled   uint64
+	ThrottledTime uint64
+}
+
+// MemoryStat contains the memory accounting of a cgroup, the unit is bytes
+type MemoryStat struct {
+	Usage uint64
+	Limit uint64
+	// High is the memory.high throttle limit of v2, it is CgroupUnlimited for v1
+	High  uint64
+	Cache uint64
+}
+
+// LoadCgroup returns the cgroup of the pid, root is the cgroup filesystem mount path
+func LoadCgroup(root string, pid int) (*Cgroup, error) {
+	return loadCgroup(root, fmt.Sprintf("/proc/%d/cgroup", pid))
+}
+
+func loadCgroup(root, procCgroupFile string) (*Cgroup, error) {
+	if root == "" {
+		root = spec.DefaultCGroupPath
+	}
+	paths, err := parseCgroupFile(procCgroupFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cgroup file %s: %s", procCgroupFile, err.Error())
+	}
+	version := CgroupV1
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		version = CgroupV2
+	} else if _, ok := paths[""]; ok && len(paths) == 1 {
+		version = CgroupV2
+	}
+	if _, ok := paths[""]; version == CgroupV2 && !ok {
+		return nil, fmt.Errorf("cgroup v2 path not found in %s", procCgroupFile)
+	}
+	return &Cgroup{version: version, root: root, paths: paths}, nil
+}
+
+// parseCgroupFile parses `/proc/$PID/cgroup`, the v2 entry `0::/path` is stored with the empty key
+func parseCgroupFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text := scanner.Text()
+		parts := strings.SplitN(text, ":", 3)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("invalid cgroup entry: %q", text)
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[strings.TrimPrefix(controller, "name=")] = parts[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// Version returns CgroupV1 or CgroupV2
+func (c *Cgroup) Version() int {
+	return c.version
+}
+
+// Path returns the cgroup directory of the controller, the controller is ignored for cgroup v2
+func (c *Cgroup) Path(controller string) (string, error) {
+	if c.version == CgroupV2 {
+		return filepath.Join(c.root, c.paths[""]), nil
+	}
+	p, ok := c.paths[controller]
+	if !ok {
+		return "", fmt.Errorf("cgroup controller %s is not supported", controller)
+	}
+	return filepath.Join(c.root, controller, p), nil
+}
+
+// ReadFile returns the trimmed content of the cgroup file
+func (c *Cgroup) ReadFile(controller, name string) (string, error) {
+	dir, err := c.Path(controller)
+	if err != nil {
+		return "", err
+	}
+	bytes, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// WriteFile writes the value to the cgroup file
+func (c *Cgroup) WriteFile(controller, name, value string) error {
+	dir, err := c.Path(controller)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644) //nolint:gosec
+}
+
+func (c *Cgroup) readUint(controller, name string) (uint64, error) {
+	value, err := c.ReadFile(controller, name)
+	if err != nil {
+		return 0, err
+	}
+	return parseCgroupUint(value)
+}
+
+// readKV reads the flat keyed files, such as cpu.stat and memory.stat
+func (c *Cgroup) readKV(controller, name string) (map[string]uint64, error) {
+	content, err := c.ReadFile(controller, name)
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[string]uint64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		kv[fields[0]] = v
+	}
+	return kv, nil
+}
+
+func parseCgroupUint(value string) (uint64, error) {
+	if value == "max" {
+		return CgroupUnlimited, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// CPUStat reads cpuacct.usage and cpu.stat for v1, cpu.stat for v2
+func (c *Cgroup) CPUStat() (*CPUStat, error) {
+	if c.version == CgroupV2 {
+		kv, err := c.readKV("cpu", "cpu.stat")
+		if err != nil {
+			return nil, err
+		}
+		return &CPUStat{
+			Usage:         kv["usage_usec"] * 1000,
+			NrPeriods:     kv["nr_periods"],
+			NrThrottled:   kv["nr_throttled"],
+			ThrottledTime: kv["throttled_usec"] * 1000,
+		}, nil
+	}
+	usage, err := c.readUint("cpuacct", "cpuacct.usage")
+	if err != nil {
+		return nil, err
+	}
+	stat := &CPUStat{Usage: usage}
+	// cpu.stat only exists if the cfs bandwidth control is enabled
+	if kv, err := c.readKV("cpu", "cpu.stat"); err == nil {
+		stat.NrPeriods = kv["nr_periods"]
+		stat.NrThrottled = kv["nr_throttled"]
+		stat.ThrottledTime = kv["throttled_time"]
+	}
+	return stat, nil
+}
+
+// CPUMax returns the cfs quota and period in microseconds, the quota is -1 if it is unlimited
+func (c *Cgroup) CPUMax() (int64, uint64, error) {
+	if c.version == CgroupV2 {
+		value, err := c.ReadFile("cpu", "cpu.max")
+		if err != nil {
+			return 0, 0, err
+		}
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return 0, 0, fmt.Errorf("invalid cpu.max content: %q", value)
+		}
+		period, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		if fields[0] == "max" {
+			return -1, period, nil
+		}
+		quota, err := strconv.ParseInt(fields[0], 10, 64)
+		return quota, period, err
+	}
+	value, err := c.ReadFile("cpu", "cpu.cfs_quota_us")
+	if err != nil {
+		return 0, 0, err
+	}
+	quota, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	period, err := c.readUint("cpu", "cpu.cfs_period_us")
+	return quota, period, err
+}
+
+// CPUCount returns the cpu count limited by the cfs quota, 0 means unlimited
+func (c *Cgroup) CPUCount() (int, error) {
+	quota, period, err := c.CPUMax()
+	if err != nil {
+		return 0, err
+	}
+	if quota <= 0 || period == 0 {
+		return 0, nil
+	}
+	return int(math.Ceil(float64(quota) / float64(period))), nil
+}
+
+// MemoryStat reads memory.current, memory.max and memory.stat for v2,
+// memory.usage_in_bytes, memory.limit_in_bytes and memory.stat for v1
+func (c *Cgroup) MemoryStat() (*MemoryStat, error) {
+	usageFile, limitFile, cacheKey := "memory.usage_in_bytes", "memory.limit_in_bytes", "cache"
+	if c.version == CgroupV2 {
+		usageFile, limitFile, cacheKey = "memory.current", "memory.max", "file"
+	}
+	usage, err := c.readUint("memory", usageFile)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := c.readUint("memory", limitFile)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := c.readKV("memory", "memory.stat")
+	if err != nil {
+		return nil, err
+	}
+	high := CgroupUnlimited
+	if c.version == CgroupV2 {
+		// memory.high does not exist in the root cgroup
+		if value, err := c.readUint("memory", "memory.high"); err == nil {
+			high = value
+		}
+	}
+	return &MemoryStat{Usage: usage, Limit: limit, High: high, Cache: kv[cacheKey]}, nil
+}
+
+// MemoryOomKills returns the count of the processes killed by the cgroup oom killer,
+// from memory.events for v2 and memory.oom_control for v1
+func (c *Cgroup) MemoryOomKills() (uint64, error) {
+	file := "memory.oom_control"
+	if c.version == CgroupV2 {
+		file = "memory.events"
+	}
+	kv, err := c.readKV("memory", file)
+	if err != nil {
+		return 0, err
+	}
+	return kv["oom_kill"], nil
+}
+
+type cgroupJSON struct {
+	Version int               `json:"version"`
+	Root    string            `json:"root"`
+	Paths   map[string]string `json:"paths"`
+}
+
+// MarshalJSON makes the cgroup can be recorded and restored on destroy
+func (c *Cgroup) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cgroupJSON{Version: c.version, Root: c.root, Paths: c.paths})
+}
+
+func (c *Cgroup) UnmarshalJSON(data []byte) error {
+	var cj cgroupJSON
+	if err := json.Unmarshal(data, &cj); err != nil {
+		return err
+	}
+	c.version, c.root, c.paths = cj.Version, cj.Root, cj.Paths
+	return nil
+}
+
+// AddProcess moves the pid into the cgroup. For cgroup v1 the pid is moved into
+// every hierarchy the cgroup belongs to, only the failures of the required
+// controllers are returned, so that a missing optional hierarchy does not fail it.
+func (c *Cgroup) AddProcess(pid int, required ...string) error {
+	value := strconv.Itoa(pid)
+	if c.version == CgroupV2 {
+		return c.WriteFile("", "cgroup.procs", value)
+	}
+	isRequired := make(map[string]bool, len(required))
+	for _, controller := range required {
+		isRequired[controller] = true
+		if _, ok := c.paths[controller]; !ok {
+			return fmt.Errorf("cgroup controller %s is not supported", controller)
+		}
+	}
+	for controller := range c.paths {
+		// the unified hierarchy of the hybrid mode is not mounted on the root
+		if controller == "" {
+			continue
+		}
+		if err := c.WriteFile(controller, "cgroup.procs", value); err != nil && isRequired[controller] {
+			return fmt.Errorf("move pid %d into the %s cgroup failed, %v", pid, controller, err)
+		}
+	}
+	return nil
+}
+
+// JoinCgroup moves the current process into the cgroup of the target pid,
+// the processes forked later are in the same cgroup
+func JoinCgroup(root, target string, required ...string) error {
+	pid, err := strconv.Atoi(target)
+	if err != nil {
+		return fmt.Errorf("illegal target pid %s, %v", target, err)
+	}
+	cg, err := LoadCgroup(root, pid)
+	if err != nil {
+		return err
+	}
+	return cg.AddProcess(os.Getpid(), required...)
+}
+
+// JoinTargetCgroup moves the burn process into the cgroup of the nsexec target pid
+func JoinTargetCgroup(ctx context.Context, model *spec.ExpModel, required ...string) *spec.Response {
+	target := model.ActionFlags[channel.NSTargetFlagName]
+	if target == "" {
+		log.Errorf(ctx, "join-cgroup requires the target pid of the nsexec channel")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, channel.NSTargetFlagName)
+	}
+	if err := JoinCgroup(model.ActionFlags["cgroup-root"], target, required...); err != nil {
+		log.Errorf(ctx, "join the cgroup of pid %s failed, %v", target, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "join cgroup", err)
+	}
+	log.Infof(ctx, "the burn process %d joined the cgroup of pid %s", os.Getpid(), target)
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadCgroupV1(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proc/cgroup": "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n",
+		"cgroup/cpuacct/docker/abc/cpuacct.usage":        "2000000000\n",
+		"cgroup/cpu/docker/abc/cpu.cfs_quota_us":         "150000\n",
+		"cgroup/cpu/docker/abc/cpu.cfs_period_us":        "100000\n",
+		"cgroup/cpu/docker/abc/cpu.stat":                 "nr_periods 10\nnr_throttled 3\nthrottled_time 500\n",
+		"cgroup/memory/docker/abc/memory.usage_in_bytes": "1024\n",
+		"cgroup/memory/docker/abc/memory.limit_in_bytes": "4096\n",
+		"cgroup/memory/docker/abc/memory.stat":           "cache 512\nrss 512\n",
+		"cgroup/memory/docker/abc/memory.oom_control":    "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n",
+	})
+	cg, err := loadCgroup(filepath.Join(dir, "cgroup"), filepath.Join(dir, "proc/cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cg.Version() != CgroupV1 {
+		t.Fatalf("expected cgroup v1, got %d", cg.Version())
+	}
+	cpuStat, err := cg.CPUStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpuStat.Usage != 2000000000 || cpuStat.NrThrottled != 3 {
+		t.Errorf("unexpected cpu stat: %+v", cpuStat)
+	}
+	if cnt, err := cg.CPUCount(); err != nil || cnt != 2 {
+		t.Errorf("expected 2 cpus, got %d, %v", cnt, err)
+	}
+	memStat, err := cg.MemoryStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memStat.Usage != 1024 || memStat.Limit != 4096 || memStat.High != CgroupUnlimited || memStat.Cache != 512 {
+		t.Errorf("unexpected memory stat: %+v", memStat)
+	}
+	if kills, err := cg.MemoryOomKills(); err != nil || kills != 2 {
+		t.Errorf("expected 2 oom kills, got %d, %v", kills, err)
+	}
+}
+
+func TestLoadCgroupV2(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proc/cgroup":                         "0::/kubepods/pod1\n",
+		"cgroup/cgroup.controllers":           "cpu memory\n",
+		"cgroup/kubepods/pod1/cpu.stat":       "usage_usec 3000\nnr_periods 5\nnr_throttled 1\nthrottled_usec 20\n",
+		"cgroup/kubepods/pod1/cpu.max":        "max 100000\n",
+		"cgroup/kubepods/pod1/memory.current": "2048\n",
+		"cgroup/kubepods/pod1/memory.max":     "max\n",
+		"cgroup/kubepods/pod1/memory.high":    "4096\n",
+		"cgroup/kubepods/pod1/memory.stat":    "anon 1024\nfile 256\n",
+		"cgroup/kubepods/pod1/memory.events":  "low 0\nhigh 7\nmax 3\noom 1\noom_kill 1\n",
+	})
+	cg, err := loadCgroup(filepath.Join(dir, "cgroup"), filepath.Join(dir, "proc/cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cg.Version() != CgroupV2 {
+		t.Fatalf("expected cgroup v2, got %d", cg.Version())
+	}
+	cpuStat, err := cg.CPUStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpuStat.Usage != 3000000 || cpuStat.ThrottledTime != 20000 {
+		t.Errorf("unexpected cpu stat: %+v", cpuStat)
+	}
+	if cnt, err := cg.CPUCount(); err != nil || cnt != 0 {
+		t.Errorf("expected unlimited cpu, got %d, %v", cnt, err)
+	}
+	memStat, err := cg.MemoryStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memStat.Usage != 2048 || memStat.Limit != CgroupUnlimited || memStat.High != 4096 || memStat.Cache != 256 {
+		t.Errorf("unexpected memory stat: %+v", memStat)
+	}
+	if kills, err := cg.MemoryOomKills(); err != nil || kills != 1 {
+		t.Errorf("expected 1 oom kill, got %d, %v", kills, err)
+	}
+}
+
+func TestCgroupAddProcess(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"proc/cgroup":                            "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n",
+		"cgroup/cpu/docker/abc/cgroup.procs":     "",
+		"cgroup/cpuacct/docker/abc/cgroup.procs": "",
+		"cgroup/memory/docker/abc/cgroup.procs":  "",
+	})
+	cg, err := loadCgroup(filepath.Join(dir, "cgroup"), filepath.Join(dir, "proc/cgroup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cg.AddProcess(42, "blkio"); err == nil {
+		t.Errorf("expected error for the missing blkio controller")
+	}
+	if err := cg.AddProcess(42, "cpu", "cpuacct"); err != nil {
+		t.Fatal(err)
+	}
+	for _, controller := range []string{"cpu", "cpuacct", "memory"} {
+		if pid, err := cg.ReadFile(controller, "cgroup.procs"); err != nil || pid != "42" {
+			t.Errorf("expected pid 42 in the %s cgroup, got %q, %v", controller, pid, err)
+		}
+	}
+}
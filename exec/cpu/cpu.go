@@ -19,12 +19,9 @@ package cpu
 import (
 	"context"
 	"fmt"
-	"os"
-	osexec "os/exec"
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/chaosblade-io/chaosblade-spec-go/channel"
@@ -41,6 +38,13 @@ import (
 
 const BurnCpuBin = "chaos_burncpu"
 
+const (
+	// ModeRelative tops the usage of the host or the container up to the cpu-percent
+	ModeRelative = "relative"
+	// ModeAbsolute makes the burn process itself consume the cpu-percent of each core
+	ModeAbsolute = "absolute"
+)
+
 type CpuCommandModelSpec struct {
 	spec.BaseExpModelCommandSpec
 }
@@ -52,7 +56,68 @@ func NewCpuCommandModelSpec() spec.ExpModelCommandSpec {
 				&FullLoadActionCommand{
 					spec.BaseExpActionCommandSpec{
 						ActionMatchers: []spec.ExpFlagSpec{},
-						ActionFlags:    []spec.ExpFlagSpec{},
+						ActionFlags: []spec.ExpFlagSpec{
+							&spec.ExpFlag{
+								Name:     "numa-node",
+								Desc:     "NUMA nodes whose cores are burned (0 or 0,1), it is expanded into the cpu-list from /sys/devices/system/node",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "socket",
+								Desc:     "Sockets whose cores are burned (0 or 0-1), it is expanded into the cpu-list by the physical_package_id of the cores",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "smt-siblings",
+								Desc:     "Cores whose hyperthread siblings are burned, the cores themselves are not burned, it is expanded into the cpu-list by the thread_siblings_list",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "mode",
+								Desc:     "load mode, relative tops the host or container usage up to cpu-percent, absolute makes the burn itself consume cpu-percent of each core regardless of other load, default value is relative",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "workload",
+								Desc:     "burn workload, spin, integer, float, simd, cache or branch, simd runs fused multiply-adds by avx2 on amd64 or neon on arm64, default value is spin",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "profile",
+								Desc:     "load profile, constant, sine, step, sawtooth or square, default value is constant",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "profile-period",
+								Desc:     "period(s) of the load profile, default value is 60",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "profile-min",
+								Desc:     "minimum percent of the load profile (0-100), default value is 0",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "profile-max",
+								Desc:     "maximum percent of the load profile (0-100), default value is cpu-percent",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "profile-amplitude",
+								Desc:     "amplitude of the load profile around cpu-percent, profile-min and profile-max take precedence",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "profile-steps",
+								Desc:     "number of steps per period for the step profile, default value is 4",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:   "join-cgroup",
+								Desc:   "Move the burn process into the cgroup of the target pid of the nsexec channel, so that the burn is accounted against the container limits",
+								NoArgs: true,
+							},
+						},
 						ActionExecutor: &cpuExecutor{},
 						ActionExample: `
 # Create a CPU full load experiment
@@ -67,13 +132,42 @@ blade create cpu load --cpu-list 0,3
 # Specify the core full load of indexes 1-3
 blade create cpu load --cpu-list 1-3
 
+# Specify different percentages per core, core 0 to 90%, core 1 to 50%, cores 2 and 3 to 20%
+blade create cpu load --cpu-list 0:90,1:50,2-3:20
+
 # Specified percentage load
-blade create cpu load --cpu-percent 60`,
+blade create cpu load --cpu-percent 60
+
+# Load swings between 20% and 80% every 5 minutes
+blade create cpu load --profile square --profile-period 600 --profile-min 20 --profile-max 80
+
+# Burn cpu inside the cgroup of the container, the burn is accounted against the container quota
+blade create cpu load --cpu-percent 60 --channel nsexec --ns_target 1234 --join-cgroup
+
+# The burn itself consumes 50% of two cores, whatever the other load of the host is
+blade create cpu load --cpu-count 2 --cpu-percent 50 --mode absolute
+
+# Burn the cores of the NUMA node 1
+blade create cpu load --numa-node 1
+
+# Burn the hyperthread siblings of the core 3 to 80%
+blade create cpu load --smt-siblings 3 --cpu-percent 80
+
+# Burn cpu with the floating point workload
+blade create cpu load --cpu-percent 60 --workload float
+
+# Sine wave load around 50% with an amplitude of 30% and a period of 30 minutes
+blade create cpu load --cpu-percent 50 --profile sine --profile-amplitude 30 --profile-period 1800`,
 						ActionPrograms:    []string{BurnCpuBin},
 						ActionCategories:  []string{category.SystemCpu},
 						ActionProcessHang: true,
 					},
 				},
+				NewThrottleActionCommandSpec(),
+				NewOfflineActionCommandSpec(),
+				NewFreqActionCommandSpec(),
+				NewContextSwitchActionCommandSpec(),
+				NewRtHogActionCommandSpec(),
 			},
 			ExpFlags: []spec.ExpFlagSpec{
 				&spec.ExpFlag{
@@ -83,7 +177,7 @@ blade create cpu load --cpu-percent 60`,
 				},
 				&spec.ExpFlag{
 					Name:     "cpu-list",
-					Desc:     "CPUs in which to allow burning (0-3 or 1,3)",
+					Desc:     "CPUs in which to allow burning (0-3 or 1,3), a percent can be appended to each part, such as 0:90,2-3:20",
 					Required: false,
 				},
 				&spec.ExpFlag{
@@ -108,6 +202,13 @@ blade create cpu load --cpu-percent 60`,
 					Required: false,
 					Default:  "/sys/fs/cgroup",
 				},
+				&spec.ExpFlag{
+					Name:     "sysfs-root",
+					Desc:     "sysfs root path, default value /sys",
+					NoArgs:   false,
+					Required: false,
+					Default:  "/sys",
+				},
 			},
 		},
 	}
@@ -152,8 +253,8 @@ func (*FullLoadActionCommand) Matchers() []spec.ExpFlagSpec {
 	return []spec.ExpFlagSpec{}
 }
 
-func (*FullLoadActionCommand) Flags() []spec.ExpFlagSpec {
-	return []spec.ExpFlagSpec{}
+func (f *FullLoadActionCommand) Flags() []spec.ExpFlagSpec {
+	return f.ActionFlags
 }
 
 type cpuExecutor struct {
@@ -198,16 +299,33 @@ func (ce *cpuExecutor) Exec(uid string, ctx context.Context, model *spec.ExpMode
 	}
 
 	cpuListStr := model.ActionFlags["cpu-list"]
+	for _, selector := range topologySelectors {
+		if model.ActionFlags[selector] == "" {
+			continue
+		}
+		if cpuListStr != "" {
+			log.Errorf(ctx, "`%s`: cpu-list is illegal, it cannot be used with %s", cpuListStr, selector)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, "it cannot be used with "+selector)
+		}
+		cpus, err := resolveTopology(model.ActionFlags["sysfs-root"],
+			model.ActionFlags["numa-node"], model.ActionFlags["socket"], model.ActionFlags["smt-siblings"])
+		if err != nil {
+			log.Errorf(ctx, "resolve the cores by the cpu topology failed, %v", err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, strings.Join(topologySelectors, "|"), model.ActionFlags[selector], err)
+		}
+		cpuListStr = formatCpuList(cpus)
+		log.Infof(ctx, "the cores selected by the cpu topology: %s", cpuListStr)
+		break
+	}
 	if cpuListStr != "" {
 		if !ce.channel.IsCommandAvailable(ctx, "taskset") {
 			return spec.ResponseFailWithFlags(spec.CommandTasksetNotFound)
 		}
-		cores, err := util.ParseIntegerListToStringSlice("cpu-list", cpuListStr)
-		if err != nil {
+		if _, err := parseCpuList(cpuListStr, cpuPercent); err != nil {
 			log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuListStr, err.Error())
 			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
 		}
-		cpuList = strings.Join(cores, ",")
+		cpuList = cpuListStr
 	} else {
 		// if cpu-list value is not empty, then the cpu-count flag is invalid
 		var err error
@@ -215,14 +333,14 @@ func (ce *cpuExecutor) Exec(uid string, ctx context.Context, model *spec.ExpMode
 		if cpuCountStr != "" {
 			cpuCount, err = strconv.Atoi(cpuCountStr)
 			if err != nil {
-				log.Errorf(ctx, "`%s`: cpu-count is illegal, cpu-count value must be a positive integer", cpuCountStr)
-				return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-count", cpuCountStr, "it must be a positive integer")
+				log.Errorf(ctx, "`%s`: cpu-count is illegal, cpu-count value must be a non-negative integer", cpuCountStr)
+				return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-count", cpuCountStr, "it must be a non-negative integer")
 			}
 		}
 
 		tmpCpuCnt := runtime.NumCPU()
 		if _, ok := ce.channel.(*channel.NSExecChannel); ok {
-			tmpCpuCnt, err = automaxprocs.GetCPUCntByPidForCgroups1(
+			tmpCpuCnt, err = getCPUCntByPid(
 				ctx,
 				model.ActionFlags["cgroup-root"],
 				model.ActionFlags[channel.NSTargetFlagName],
@@ -242,41 +360,74 @@ func (ce *cpuExecutor) Exec(uid string, ctx context.Context, model *spec.ExpMode
 		var err error
 		climbTime, err = strconv.Atoi(climbTimeStr)
 		if err != nil {
-			log.Errorf(ctx, "`%s`: climb-time is illegal, climb-time value must be a positive integer", climbTimeStr)
-			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "climb-time", climbTimeStr, "it must be a positive integer")
+			log.Errorf(ctx, "`%s`: climb-time is illegal, climb-time value must be a non-negative integer", climbTimeStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "climb-time", climbTimeStr, "it must be a non-negative integer")
 		}
 		if climbTime > 600 || climbTime < 0 {
-			log.Errorf(ctx, "`%s`: climb-time is illegal, climb-time value must be a positive integer and not bigger than 600", climbTimeStr)
-			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "climb-time", climbTimeStr, "must be a positive integer and not bigger than 600")
+			log.Errorf(ctx, "`%s`: climb-time is illegal, climb-time value must be a non-negative integer and not bigger than 600", climbTimeStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "climb-time", climbTimeStr, "must be a non-negative integer and not bigger than 600")
 		}
 	}
 
+	profile, resp := parseLoadProfile(ctx, model.ActionFlags, cpuPercent)
+	if resp != nil {
+		return resp
+	}
+	if profile != nil && strings.Contains(cpuList, ":") {
+		log.Errorf(ctx, "`%s`: cpu-list is illegal, per-core percent cannot be used with the %s profile", cpuList, profile.kind)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuList, "per-core percent cannot be used with the profile flag")
+	}
+	if profile != nil && climbTime != 0 {
+		log.Errorf(ctx, "`%s`: climb-time is illegal, it cannot be used with the %s profile", climbTimeStr, profile.kind)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "climb-time", climbTimeStr, "it cannot be used with the profile flag")
+	}
+
+	workload := model.ActionFlags["workload"]
+	if _, ok := workloads[workload]; workload != "" && !ok {
+		log.Errorf(ctx, "`%s`: workload is illegal, it must be one of spin, integer, float, simd, cache and branch", workload)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "workload", workload, "it must be one of spin, integer, float, simd, cache and branch")
+	}
+
+	mode := model.ActionFlags["mode"]
+	if mode == "" {
+		mode = ModeRelative
+	}
+	if mode != ModeRelative && mode != ModeAbsolute {
+		log.Errorf(ctx, "`%s`: mode is illegal, it must be relative or absolute", mode)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "mode", mode, "it must be relative or absolute")
+	}
+
 	ctx = context.WithValue(ctx, "cgroup-root", model.ActionFlags["cgroup-root"])
 
-	return ce.start(ctx, cpuList, cpuCount, cpuPercent, climbTime, model.ActionFlags["cpu-index"])
+	if model.ActionFlags["join-cgroup"] == spec.True {
+		if resp := exec.JoinTargetCgroup(ctx, model, "cpu", "cpuacct"); resp != nil {
+			return resp
+		}
+	}
+
+	return ce.start(ctx, cpuList, cpuCount, cpuPercent, climbTime, model.ActionFlags["cpu-index"], profile, workload, mode)
 }
 
 // start burn cpu
-func (ce *cpuExecutor) start(ctx context.Context, cpuList string, cpuCount, cpuPercent, climbTime int, cpuIndexStr string) *spec.Response {
+func (ce *cpuExecutor) start(ctx context.Context, cpuList string, cpuCount, cpuPercent, climbTime int, cpuIndexStr string,
+	profile *loadProfile, workload, mode string) *spec.Response {
 	ctx = context.WithValue(ctx, "cpuCount", cpuCount)
 	if cpuList != "" {
-		cores, err := util.ParseIntegerListToStringSlice("cpu-list", cpuList)
+		cores, err := parseCpuList(cpuList, cpuPercent)
 		if err != nil {
 			log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuList, err.Error())
 			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuList, err.Error())
 		}
-		for _, core := range cores {
-
-			args := fmt.Sprintf(`%s create cpu fullload --cpu-count 1 --cpu-percent %d --climb-time %d --cpu-index %s --uid %s`,
-				os.Args[0], cpuPercent, climbTime, core, ctx.Value(spec.Uid))
-
-			args = fmt.Sprintf("-c %s %s", core, args)
-			argsArray := strings.Split(args, " ")
-			command := osexec.CommandContext(ctx, "taskset", argsArray...)
-			command.SysProcAttr = &syscall.SysProcAttr{}
-
-			if err := command.Start(); err != nil {
-				return spec.ReturnFail(spec.OsCmdExecFailed, fmt.Sprintf("taskset exec failed, %v", err))
+		for _, cp := range cores {
+			core := cp.core
+			args := fmt.Sprintf(`create cpu fullload --cpu-count 1 --cpu-percent %d --climb-time %d --cpu-index %s --mode %s --uid %s`,
+				cp.percent, climbTime, core, mode, ctx.Value(spec.Uid))
+			args += profile.args()
+			if workload != "" {
+				args = fmt.Sprintf("%s --workload %s", args, workload)
+			}
+			if resp := exec.StartPinned(ctx, core, args); resp != nil {
+				return resp
 			}
 		}
 		return spec.ReturnSuccess(ctx.Value(spec.Uid))
@@ -284,7 +435,8 @@ func (ce *cpuExecutor) start(ctx context.Context, cpuList string, cpuCount, cpuP
 
 	runtime.GOMAXPROCS(cpuCount)
 	log.Debugf(ctx, "cpu counts: %d", cpuCount)
-	slopePercent := float64(cpuPercent)
+	slopePercent := &atomicFloat{}
+	slopePercent.store(float64(cpuPercent))
 
 	var cpuIndex int
 	percpu := false
@@ -293,84 +445,130 @@ func (ce *cpuExecutor) start(ctx context.Context, cpuList string, cpuCount, cpuP
 		var err error
 		cpuIndex, err = strconv.Atoi(cpuIndexStr)
 		if err != nil {
-			log.Errorf(ctx, "`%s`: cpu-index is illegal, cpu-index value must be a positive integer", cpuIndexStr)
-			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-index", cpuIndexStr, "it must be a positive integer")
+			log.Errorf(ctx, "`%s`: cpu-index is illegal, cpu-index value must be a non-negative integer", cpuIndexStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-index", cpuIndexStr, "it must be a non-negative integer")
 		}
 	}
 
-	// make CPU slowly climb to some level, to simulate slow resource competition
-	// which system faults cannot be quickly noticed by monitoring system.
-	slope(ctx, cpuPercent, climbTime, &slopePercent, percpu, cpuIndex)
+	if profile != nil {
+		// change the target percent periodically, such as sine or square wave
+		profile.drive(ctx, slopePercent)
+	} else {
+		// make CPU slowly climb to some level, to simulate slow resource competition
+		// which system faults cannot be quickly noticed by monitoring system.
+		slope(ctx, cpuPercent, climbTime, slopePercent, percpu, cpuIndex, mode == ModeAbsolute)
+	}
 
-	quota := make(chan int64, cpuCount)
+	var sampler usageSampler
+	var err error
+	if mode == ModeAbsolute {
+		sampler = newSelfSampler(cpuCount)
+	} else {
+		sampler, err = newUsageSampler(ctx, percpu, cpuIndex)
+	}
+	if err != nil {
+		log.Errorf(ctx, "get cpu usage fail, %s", err.Error())
+		return spec.ReturnFail(spec.OsCmdExecFailed, fmt.Sprintf("get cpu usage fail, %v", err))
+	}
+
+	gain := controlGain(ctx, cpuCount, percpu, mode)
+	duty := &atomicFloat{}
 	for i := 0; i < cpuCount; i++ {
-		go burn(ctx, quota, slopePercent, percpu, cpuIndex)
+		work, _ := newWorkload(workload)
+		go burn(ctx, duty, work)
 	}
+	control(ctx, sampler, slopePercent, duty, newCpuController(gain))
+	return spec.ReturnSuccess(ctx.Value(spec.Uid))
+}
 
-	for {
-		q := getQuota(ctx, slopePercent, percpu, cpuIndex)
-		for i := 0; i < cpuCount; i++ {
-			quota <- q
+// corePercent is the target percent of a core
+type corePercent struct {
+	core    string
+	percent int
+}
+
+// parseCpuList parses the cpu list such as 0-3 or 0:90,1:50,2-3:20, the part
+// without the percent suffix uses the defaultPercent
+func parseCpuList(cpuList string, defaultPercent int) ([]corePercent, error) {
+	result := make([]corePercent, 0)
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(cpuList, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		percent := defaultPercent
+		if idx := strings.Index(part, ":"); idx >= 0 {
+			var err error
+			percent, err = strconv.Atoi(strings.TrimSpace(part[idx+1:]))
+			if err != nil || percent < 0 || percent > 100 {
+				return nil, fmt.Errorf("the percent of %s must be a positive integer and not bigger than 100", part)
+			}
+			part = part[:idx]
+		}
+		cores, err := util.ParseIntegerListToStringSlice("cpu-list", part)
+		if err != nil {
+			return nil, err
+		}
+		for _, core := range cores {
+			if seen[core] {
+				return nil, fmt.Errorf("core %s is duplicated", core)
+			}
+			seen[core] = true
+			result = append(result, corePercent{core: core, percent: percent})
 		}
 	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no core specified")
+	}
+	return result, nil
 }
 
-const period = int64(1000000000)
+// getCPUCntByPid returns the cpu count limited by the cgroup of the target pid
+func getCPUCntByPid(ctx context.Context, cgroupRoot, pid string) (int, error) {
+	p, err := strconv.Atoi(pid)
+	if err != nil {
+		return runtime.NumCPU(), err
+	}
+	cg, err := exec.LoadCgroup(cgroupRoot, p)
+	if err != nil || cg.Version() == exec.CgroupV1 {
+		return automaxprocs.GetCPUCntByPidForCgroups1(ctx, cgroupRoot, pid)
+	}
+	cnt, err := cg.CPUCount()
+	if err != nil {
+		return runtime.NumCPU(), err
+	}
+	if cnt <= 0 {
+		log.Warnf(ctx, "cpu quota undefined, pid: %s, use NumCPU instead", pid)
+		return runtime.NumCPU(), nil
+	}
+	log.Infof(ctx, "get numCPU count by pid %s, cgroups2 cpu quota: %d", pid, cnt)
+	return cnt, nil
+}
 
-func slope(ctx context.Context, cpuPercent int, climbTime int, slopePercent *float64, percpu bool, cpuIndex int) {
+// slope climbs from the current usage, or from zero for the absolute mode
+func slope(ctx context.Context, cpuPercent int, climbTime int, slopePercent *atomicFloat, percpu bool, cpuIndex int, absolute bool) {
 	if climbTime != 0 {
 		var ticker = time.NewTicker(time.Second)
-		*slopePercent = getUsed(ctx, percpu, cpuIndex)
-		var startPercent = float64(cpuPercent) - *slopePercent
+		var percent float64
+		if !absolute {
+			percent = getUsed(ctx, percpu, cpuIndex)
+		}
+		slopePercent.store(percent)
+		var startPercent = float64(cpuPercent) - percent
 		go func() {
 			for range ticker.C {
-				if *slopePercent < float64(cpuPercent) {
-					*slopePercent += startPercent / float64(climbTime)
-				} else if *slopePercent > float64(cpuPercent) {
-					*slopePercent -= startPercent / float64(climbTime)
+				if percent < float64(cpuPercent) {
+					percent += startPercent / float64(climbTime)
+				} else if percent > float64(cpuPercent) {
+					percent -= startPercent / float64(climbTime)
 				}
+				slopePercent.store(percent)
 			}
 		}()
 	}
 }
 
-func getQuota(ctx context.Context, slopePercent float64, percpu bool, cpuIndex int) int64 {
-	used := getUsed(ctx, percpu, cpuIndex)
-	log.Debugf(ctx, "cpu usage: %f , percpu: %v, cpuIndex %d", used, percpu, cpuIndex)
-	dx := (slopePercent - used) / 100
-	busy := int64(dx * float64(period))
-	return busy
-}
-
-func burn(ctx context.Context, quota <-chan int64, slopePercent float64, percpu bool, cpuIndex int) {
-	q := getQuota(ctx, slopePercent, percpu, cpuIndex)
-	ds := period - q
-	if ds < 0 {
-		ds = 0
-	}
-	s, _ := time.ParseDuration(strconv.FormatInt(ds, 10) + "ns")
-	for {
-		startTime := time.Now().UnixNano()
-		select {
-		case offset := <-quota:
-			q = q + offset
-			if q < 0 {
-				q = 0
-			}
-			ds := period - q
-			if ds < 0 {
-				ds = 0
-			}
-			s, _ = time.ParseDuration(strconv.FormatInt(ds, 10) + "ns")
-		default:
-			for time.Now().UnixNano()-startTime < q {
-			}
-			runtime.Gosched()
-			time.Sleep(s)
-		}
-	}
-}
-
 // stop burn cpu
 func (ce *cpuExecutor) stop(ctx context.Context) *spec.Response {
 	ctx = context.WithValue(ctx, "bin", BurnCpuBin)
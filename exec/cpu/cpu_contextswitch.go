@@ -0,0 +1,287 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const (
+	MechanismFutex = "futex"
+	MechanismPipe  = "pipe"
+)
+
+type ContextSwitchActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewContextSwitchActionCommandSpec() spec.ExpActionCommandSpec {
+	return &ContextSwitchActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "pairs",
+					Desc: "The number of thread pairs, it is per core if cpu-list is specified. The default value is the cpu count, or 1 per core",
+				},
+				&spec.ExpFlag{
+					Name: "mechanism",
+					Desc: "The mechanism to wake up the peer thread, futex or pipe, default value is futex",
+				},
+				&spec.ExpFlag{
+					Name: "rate",
+					Desc: "The maximum round trips per second of each pair, 0 means unlimited, default value is 0",
+				},
+			},
+			ActionExecutor: &contextSwitchExecutor{},
+			ActionExample: `
+# Create a context switch storm with a thread pair per cpu
+blade create cpu contextswitch
+
+# Ping-pong 8 thread pairs over pipes on each of the cores 0 and 1
+blade create cpu contextswitch --cpu-list 0,1 --pairs 8 --mechanism pipe
+
+# Limit each pair to 10000 round trips per second, so that the cpu is not saturated
+blade create cpu contextswitch --pairs 16 --rate 10000`,
+			ActionCategories:  []string{category.SystemCpu},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*ContextSwitchActionCommandSpec) Name() string {
+	return "contextswitch"
+}
+
+func (*ContextSwitchActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*ContextSwitchActionCommandSpec) ShortDesc() string {
+	return "cpu context switch storm"
+}
+
+func (c *ContextSwitchActionCommandSpec) LongDesc() string {
+	if c.ActionLongDesc != "" {
+		return c.ActionLongDesc
+	}
+	return "Create thread pairs which wake up each other over futexes or pipes, to drive very high context switch and run queue rates without necessarily saturating the cpu"
+}
+
+type contextSwitchExecutor struct {
+	channel spec.Channel
+}
+
+func (ce *contextSwitchExecutor) Name() string {
+	return "contextswitch"
+}
+
+func (ce *contextSwitchExecutor) SetChannel(channel spec.Channel) {
+	ce.channel = channel
+}
+
+func (ce *contextSwitchExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if ce.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return exec.Destroy(ctx, ce.channel, "cpu contextswitch")
+	}
+
+	var cores []string
+	cpuListStr := model.ActionFlags["cpu-list"]
+	if cpuListStr != "" {
+		if !ce.channel.IsCommandAvailable(ctx, "taskset") {
+			return spec.ResponseFailWithFlags(spec.CommandTasksetNotFound)
+		}
+		var err error
+		cores, err = util.ParseIntegerListToStringSlice("cpu-list", cpuListStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuListStr, err.Error())
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
+		}
+		cores = util.RemoveDuplicates(cores)
+	}
+
+	pairs := runtime.NumCPU()
+	if len(cores) > 0 {
+		pairs = 1
+	}
+	pairsStr := model.ActionFlags["pairs"]
+	if pairsStr != "" {
+		var err error
+		pairs, err = strconv.Atoi(pairsStr)
+		if err != nil || pairs <= 0 {
+			log.Errorf(ctx, "`%s`: pairs is illegal, it must be a positive integer", pairsStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "pairs", pairsStr, "it must be a positive integer")
+		}
+	}
+
+	mechanism := model.ActionFlags["mechanism"]
+	if mechanism == "" {
+		mechanism = MechanismFutex
+	}
+	if mechanism != MechanismFutex && mechanism != MechanismPipe {
+		log.Errorf(ctx, "`%s`: mechanism is illegal, it must be futex or pipe", mechanism)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "mechanism", mechanism, "it must be futex or pipe")
+	}
+
+	var rate int
+	rateStr := model.ActionFlags["rate"]
+	if rateStr != "" {
+		var err error
+		rate, err = strconv.Atoi(rateStr)
+		if err != nil || rate < 0 {
+			log.Errorf(ctx, "`%s`: rate is illegal, it must be a non-negative integer", rateStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "rate", rateStr, "it must be a non-negative integer")
+		}
+	}
+	return ce.start(ctx, cores, pairs, mechanism, rate)
+}
+
+// start creates the thread pairs, if the cores are specified, a process pinned
+// to each core by taskset is created, the same as cpu fullload
+func (ce *contextSwitchExecutor) start(ctx context.Context, cores []string, pairs int, mechanism string, rate int) *spec.Response {
+	if len(cores) > 0 {
+		for _, core := range cores {
+			args := fmt.Sprintf(`create cpu contextswitch --pairs %d --mechanism %s --rate %d --cpu-index %s --uid %s`,
+				pairs, mechanism, rate, core, ctx.Value(spec.Uid))
+			if resp := exec.StartPinned(ctx, core, args); resp != nil {
+				return resp
+			}
+		}
+		return spec.ReturnSuccess(ctx.Value(spec.Uid))
+	}
+
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Second / time.Duration(rate)
+	}
+	var rounds uint64
+	for i := 0; i < pairs; i++ {
+		t, err := newToken(mechanism)
+		if err != nil {
+			log.Errorf(ctx, "create %s thread pair failed, %v", mechanism, err)
+			return spec.ReturnFail(spec.OsCmdExecFailed, fmt.Sprintf("create %s thread pair failed, %v", mechanism, err))
+		}
+		go pingPong(ctx, t, 0, &rounds, interval)
+		go pingPong(ctx, t, 1, nil, 0)
+	}
+	log.Infof(ctx, "%d %s thread pairs are created", pairs, mechanism)
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	last, lastRounds := time.Now(), uint64(0)
+	for now := range ticker.C {
+		r := atomic.LoadUint64(&rounds)
+		// every round trip switches to the peer and back
+		log.Infof(ctx, "context switches per second: %.0f", float64(r-lastRounds)*2/now.Sub(last).Seconds())
+		last, lastRounds = now, r
+	}
+	return spec.ReturnSuccess(ctx.Value(spec.Uid))
+}
+
+// token is passed back and forth between the two threads of a pair,
+// the thread blocks in the kernel until the peer passes the token to it
+type token interface {
+	// wait blocks until the token is passed to the side
+	wait(side int) error
+	// pass passes the token from the side to the peer
+	pass(side int) error
+}
+
+func newToken(mechanism string) (token, error) {
+	if mechanism == MechanismPipe {
+		return newPipeToken()
+	}
+	return newFutexToken()
+}
+
+// pingPong runs one side of a thread pair on a locked os thread, the side 0
+// starts the round trip, counts the rounds and limits the rate
+func pingPong(ctx context.Context, t token, side int, rounds *uint64, interval time.Duration) {
+	runtime.LockOSThread()
+	for {
+		var err error
+		if side == 0 {
+			if err = t.pass(side); err == nil {
+				err = t.wait(side)
+			}
+		} else {
+			if err = t.wait(side); err == nil {
+				err = t.pass(side)
+			}
+		}
+		if err != nil {
+			log.Fatalf(ctx, "context switch thread failed, %v", err)
+		}
+		if rounds != nil {
+			atomic.AddUint64(rounds, 1)
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// pipeToken passes a byte over the blocking pipe of each direction
+type pipeToken struct {
+	// fds[side] is the pipe written by the side
+	fds [2][2]int
+}
+
+func newPipeToken() (*pipeToken, error) {
+	t := &pipeToken{}
+	for side := 0; side < 2; side++ {
+		if err := syscall.Pipe(t.fds[side][:]); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *pipeToken) wait(side int) error {
+	buf := make([]byte, 1)
+	for {
+		_, err := syscall.Read(t.fds[1-side][0], buf)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func (t *pipeToken) pass(side int) error {
+	for {
+		_, err := syscall.Write(t.fds[side][1], []byte{1})
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	futexWaitPrivate = 128
+	futexWakePrivate = 129
+)
+
+// futexToken passes the turn by a shared word, the waiting side sleeps on the futex of the word
+type futexToken struct {
+	turn uint32
+}
+
+func newFutexToken() (token, error) {
+	return &futexToken{}, nil
+}
+
+func (t *futexToken) wait(side int) error {
+	for {
+		turn := atomic.LoadUint32(&t.turn)
+		if turn == uint32(side) {
+			return nil
+		}
+		// EAGAIN means the turn has been changed, EINTR means interrupted, both are retried
+		if errno := futex(&t.turn, futexWaitPrivate, turn); errno != 0 && errno != syscall.EAGAIN && errno != syscall.EINTR {
+			return errno
+		}
+	}
+}
+
+func (t *futexToken) pass(side int) error {
+	atomic.StoreUint32(&t.turn, uint32(1-side))
+	if errno := futex(&t.turn, futexWakePrivate, 1); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func futex(addr *uint32, op int, val uint32) syscall.Errno {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), uintptr(op), uintptr(val), 0, 0, 0)
+	return errno
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/shirou/gopsutil/cpu"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+)
+
+const (
+	// sampleInterval is the interval of the cpu usage sampling and the controller update
+	sampleInterval = 200 * time.Millisecond
+	// burnCycle is the busy+idle cycle of the burn goroutines, it is shorter than
+	// the sample interval, so that each sample sees the average duty cycle
+	burnCycle = 50 * time.Millisecond
+	// reportInterval is the interval of logging the achieved-vs-target error
+	reportInterval = 10 * time.Second
+)
+
+// usageSampler returns the cpu usage percent since the last call without blocking
+type usageSampler interface {
+	sample() (float64, error)
+}
+
+// hostSampler samples the host or per-cpu usage from /proc/stat
+type hostSampler struct {
+	percpu   bool
+	cpuIndex int
+	prev     cpu.TimesStat
+}
+
+func newHostSampler(percpu bool, cpuIndex int) (*hostSampler, error) {
+	s := &hostSampler{percpu: percpu, cpuIndex: cpuIndex}
+	var err error
+	s.prev, err = s.times()
+	return s, err
+}
+
+func (s *hostSampler) times() (cpu.TimesStat, error) {
+	times, err := cpu.Times(s.percpu)
+	if err != nil {
+		return cpu.TimesStat{}, err
+	}
+	index := 0
+	if s.percpu {
+		index = s.cpuIndex
+	}
+	if index >= len(times) {
+		return cpu.TimesStat{}, fmt.Errorf("illegal cpu index %d", index)
+	}
+	return times[index], nil
+}
+
+func (s *hostSampler) sample() (float64, error) {
+	t, err := s.times()
+	if err != nil {
+		return 0, err
+	}
+	busy := func(t cpu.TimesStat) float64 {
+		return t.User + t.System + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+	}
+	all, allBusy := t.Total()-s.prev.Total(), busy(t)-busy(s.prev)
+	s.prev = t
+	if all <= 0 || allBusy <= 0 {
+		return 0, nil
+	}
+	return math.Min(100, allBusy/all*100), nil
+}
+
+// cgroupSampler samples the usage of the cgroup from cpuacct.usage or cpu.stat
+type cgroupSampler struct {
+	cgroup   *exec.Cgroup
+	cpuCount int
+	prev     uint64
+	prevTime time.Time
+}
+
+func newCgroupSampler(cg *exec.Cgroup, cpuCount int) (*cgroupSampler, error) {
+	stat, err := cg.CPUStat()
+	if err != nil {
+		return nil, err
+	}
+	return &cgroupSampler{cgroup: cg, cpuCount: cpuCount, prev: stat.Usage, prevTime: time.Now()}, nil
+}
+
+func (s *cgroupSampler) sample() (float64, error) {
+	stat, err := s.cgroup.CPUStat()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	elapsed := now.Sub(s.prevTime)
+	prev := s.prev
+	s.prev, s.prevTime = stat.Usage, now
+	if stat.Usage < prev {
+		return 0, nil
+	}
+	return usagePercent(time.Duration(stat.Usage-prev), elapsed, s.cpuCount), nil
+}
+
+// selfSampler samples the cpu usage of the burn process itself by getrusage, it is used
+// by the absolute mode, so that the other load of the host is not counted
+type selfSampler struct {
+	cpuCount int
+	prev     time.Duration
+	prevTime time.Time
+}
+
+func newSelfSampler(cpuCount int) *selfSampler {
+	return &selfSampler{cpuCount: cpuCount, prev: processCPUTime(), prevTime: time.Now()}
+}
+
+func (s *selfSampler) sample() (float64, error) {
+	used, now := processCPUTime(), time.Now()
+	elapsed := now.Sub(s.prevTime)
+	prev := s.prev
+	s.prev, s.prevTime = used, now
+	if used < prev {
+		return 0, nil
+	}
+	return usagePercent(used-prev, elapsed, s.cpuCount), nil
+}
+
+// usagePercent returns the cpu time used in the elapsed wall time as the percent of the cpus
+func usagePercent(used, elapsed time.Duration, cpuCount int) float64 {
+	if elapsed <= 0 || cpuCount <= 0 {
+		return 0
+	}
+	return float64(used) * 100 / float64(elapsed) / float64(cpuCount)
+}
+
+// processCPUTime returns the user and system cpu time of the current process
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// getUsed returns the cpu usage percent in one second
+func getUsed(ctx context.Context, percpu bool, cpuIndex int) float64 {
+	sampler, err := newUsageSampler(ctx, percpu, cpuIndex)
+	if err != nil {
+		log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
+	}
+	time.Sleep(time.Second)
+	used, err := sampler.sample()
+	if err != nil {
+		log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
+	}
+	return used
+}
+
+// pidController is a PID controller with the output clamped to [min, max],
+// the integral stops accumulating while the output is saturated (anti-windup)
+type pidController struct {
+	kp, ki, kd float64
+	min, max   float64
+	integral   float64
+	prevErr    float64
+	started    bool
+}
+
+// update returns the controller output for the error e over dt seconds
+func (c *pidController) update(e, dt float64) float64 {
+	derivative := 0.0
+	if c.started && dt > 0 {
+		derivative = (e - c.prevErr) / dt
+	}
+	c.prevErr, c.started = e, true
+
+	integral := c.integral + c.ki*e*dt
+	u := c.kp*e + integral + c.kd*derivative
+	if u > c.max {
+		u = c.max
+		// only integrate if the error drives the output back from the bound
+		if e < 0 {
+			c.integral = integral
+		}
+	} else if u < c.min {
+		u = c.min
+		if e > 0 {
+			c.integral = integral
+		}
+	} else {
+		c.integral = integral
+	}
+	c.integral = math.Max(c.min, math.Min(c.max, c.integral))
+	return u
+}
+
+// controlGain returns the usage percent increased by the full duty cycle divided by 100, the container
+// usage, the per-cpu usage and the usage of the burn itself are measured against the burned cores,
+// the host usage against all cores
+func controlGain(ctx context.Context, cpuCount int, percpu bool, mode string) float64 {
+	if ctx.Value(channel.NSTargetFlagName) != nil || percpu || mode == ModeAbsolute {
+		return 1
+	}
+	return float64(cpuCount) / float64(runtime.NumCPU())
+}
+
+// newCpuController returns the controller whose output is the duty cycle of the burn goroutines,
+// the gain is the usage percent increased by the full duty cycle divided by 100
+func newCpuController(gain float64) *pidController {
+	if gain <= 0 {
+		gain = 1
+	}
+	return &pidController{
+		kp:  0.3 / 100 / gain,
+		ki:  2.0 / 100 / gain,
+		kd:  0,
+		min: 0,
+		max: 1,
+	}
+}
+
+// atomicFloat is a float64 shared between goroutines, such as the target percent
+// and the busy ratio of the burn goroutines
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat) store(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+// control samples the cpu usage and adjusts the duty cycle to make the usage reach the target
+func control(ctx context.Context, sampler usageSampler, slopePercent *atomicFloat, duty *atomicFloat, controller *pidController) {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	lastReport := last
+	var errSum, absErrSum float64
+	var samples int
+	for now := range ticker.C {
+		used, err := sampler.sample()
+		if err != nil {
+			log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
+		}
+		target := slopePercent.load()
+		e := target - used
+		d := controller.update(e, now.Sub(last).Seconds())
+		duty.store(d)
+		last = now
+		log.Debugf(ctx, "cpu usage: %f, target: %f, duty: %f", used, target, d)
+
+		errSum += e
+		absErrSum += math.Abs(e)
+		samples++
+		if now.Sub(lastReport) >= reportInterval {
+			log.Infof(ctx, "cpu target: %.2f%%, achieved: %.2f%%, mean error: %.2f%%, mean absolute error: %.2f%%, duty: %.3f",
+				target, used, errSum/float64(samples), absErrSum/float64(samples), d)
+			errSum, absErrSum, samples, lastReport = 0, 0, 0, now
+		}
+	}
+}
+
+func burn(ctx context.Context, duty *atomicFloat, work func()) {
+	for {
+		busy := time.Duration(duty.load() * float64(burnCycle))
+		start := time.Now()
+		for time.Since(start) < busy {
+			work()
+		}
+		runtime.Gosched()
+		if idle := burnCycle - busy; idle > 0 {
+			time.Sleep(idle)
+		}
+	}
+}
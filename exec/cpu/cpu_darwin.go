@@ -18,21 +18,8 @@ package cpu
 
 import (
 	"context"
-	"github.com/chaosblade-io/chaosblade-spec-go/log"
-	"github.com/shirou/gopsutil/cpu"
-	"time"
 )
 
-func getUsed(ctx context.Context, percpu bool, cpuIndex int) float64 {
-	totalCpuPercent, err := cpu.Percent(time.Second, percpu)
-	if err != nil {
-		log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
-	}
-	if percpu {
-		if cpuIndex > len(totalCpuPercent) {
-			log.Fatalf(ctx, "illegal cpu index %d", cpuIndex)
-		}
-		return totalCpuPercent[cpuIndex]
-	}
-	return totalCpuPercent[0]
+func newUsageSampler(ctx context.Context, percpu bool, cpuIndex int) (usageSampler, error) {
+	return newHostSampler(percpu, cpuIndex)
 }
@@ -0,0 +1,293 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const freqAction = "cpu-freq"
+
+const (
+	scalingGovernor           = "scaling_governor"
+	scalingMinFreq            = "scaling_min_freq"
+	scalingMaxFreq            = "scaling_max_freq"
+	scalingAvailableGovernors = "scaling_available_governors"
+	cpuinfoMinFreq            = "cpuinfo_min_freq"
+)
+
+type FreqActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewFreqActionCommandSpec() spec.ExpActionCommandSpec {
+	return &FreqActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "governor",
+					Desc: "The cpufreq governor to switch to, such as powersave",
+				},
+				&spec.ExpFlag{
+					Name: "max-freq",
+					Desc: "The cap of scaling_max_freq, unit is kHz",
+				},
+			},
+			ActionExecutor: &freqExecutor{},
+			ActionExample: `
+# Switch the governor of all online cores to powersave
+blade create cpu freq --governor powersave
+
+# Cap the frequency of the cores 0-3 to 1.2GHz
+blade create cpu freq --cpu-list 0-3 --max-freq 1200000`,
+			ActionCategories: []string{category.SystemCpu},
+		},
+	}
+}
+
+func (*FreqActionCommandSpec) Name() string {
+	return "freq"
+}
+
+func (*FreqActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*FreqActionCommandSpec) ShortDesc() string {
+	return "cpu frequency scaling"
+}
+
+func (f *FreqActionCommandSpec) LongDesc() string {
+	if f.ActionLongDesc != "" {
+		return f.ActionLongDesc
+	}
+	return "Switch the cpufreq governor or cap the scaling_max_freq of the cores to emulate thermal throttling, the original governor and frequencies are restored when the experiment is destroyed"
+}
+
+type freqExecutor struct {
+	channel spec.Channel
+}
+
+func (fe *freqExecutor) Name() string {
+	return "freq"
+}
+
+func (fe *freqExecutor) SetChannel(channel spec.Channel) {
+	fe.channel = channel
+}
+
+// freqState is the cpufreq policy of a core
+type freqState struct {
+	Cpu      int    `json:"cpu"`
+	Governor string `json:"governor"`
+	MinFreq  string `json:"minFreq"`
+	MaxFreq  string `json:"maxFreq"`
+}
+
+type freqRecord struct {
+	SysfsRoot string      `json:"sysfsRoot"`
+	States    []freqState `json:"states"`
+}
+
+func (fe *freqExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if fe.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return fe.stop(ctx, uid)
+	}
+
+	sysfsRoot := model.ActionFlags["sysfs-root"]
+	governor := model.ActionFlags["governor"]
+	maxFreqStr := model.ActionFlags["max-freq"]
+	var maxFreq int
+	if governor == "" && maxFreqStr == "" {
+		log.Errorf(ctx, "governor or max-freq is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "governor|max-freq")
+	}
+	if maxFreqStr != "" {
+		var err error
+		maxFreq, err = strconv.Atoi(maxFreqStr)
+		if err != nil || maxFreq <= 0 {
+			log.Errorf(ctx, "`%s`: max-freq is illegal, it must be a positive integer", maxFreqStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "max-freq", maxFreqStr, "it must be a positive integer")
+		}
+	}
+
+	var cpus []int
+	var err error
+	cpuListStr := model.ActionFlags["cpu-list"]
+	if cpuListStr != "" {
+		cores, err := util.ParseIntegerListToStringSlice("cpu-list", cpuListStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuListStr, err.Error())
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
+		}
+		for _, core := range util.RemoveDuplicates(cores) {
+			c, _ := strconv.Atoi(core)
+			cpus = append(cpus, c)
+		}
+	} else if cpus, err = onlineCpus(sysfsRoot); err != nil {
+		log.Errorf(ctx, "get online cpus failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get online cpus", err)
+	}
+
+	for _, cpu := range cpus {
+		if governor != "" {
+			available, err := readCpufreq(sysfsRoot, cpu, scalingAvailableGovernors)
+			if err != nil {
+				log.Errorf(ctx, "get available governors of cpu%d failed, %v", cpu, err)
+				return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get available governors", err)
+			}
+			if !strings.Contains(" "+available+" ", " "+governor+" ") {
+				log.Errorf(ctx, "`%s`: governor is illegal, available governors of cpu%d: %s", governor, cpu, available)
+				return spec.ResponseFailWithFlags(spec.ParameterIllegal, "governor", governor, "available governors: "+available)
+			}
+		}
+	}
+	return fe.start(ctx, uid, sysfsRoot, cpus, governor, maxFreq)
+}
+
+func (fe *freqExecutor) start(ctx context.Context, uid, sysfsRoot string, cpus []int, governor string, maxFreq int) *spec.Response {
+	record := &freqRecord{SysfsRoot: sysfsRoot}
+	for _, cpu := range cpus {
+		state, err := snapshotCpufreq(sysfsRoot, cpu)
+		if err != nil {
+			log.Errorf(ctx, "get cpufreq policy of cpu%d failed, %v", cpu, err)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get cpufreq policy", err)
+		}
+		record.States = append(record.States, *state)
+	}
+	if err := exec.SaveRecord(freqAction, uid, record); err != nil {
+		log.Errorf(ctx, "save the original cpufreq policy failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the original cpufreq policy", err)
+	}
+
+	for _, state := range record.States {
+		if err := applyCpufreq(sysfsRoot, state, governor, maxFreq); err != nil {
+			log.Errorf(ctx, "change cpufreq policy of cpu%d failed, %v", state.Cpu, err)
+			fe.restore(ctx, record)
+			exec.RemoveRecord(freqAction, uid)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, fmt.Sprintf("change cpufreq policy of cpu%d", state.Cpu), err)
+		}
+		log.Infof(ctx, "change cpufreq policy of cpu%d, governor: %s, max freq: %d", state.Cpu, governor, maxFreq)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func (fe *freqExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record freqRecord
+	if err := exec.LoadRecord(freqAction, uid, &record); err != nil {
+		log.Errorf(ctx, "load the original cpufreq policy failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the original cpufreq policy", err)
+	}
+	if failed := fe.restore(ctx, &record); len(failed) > 0 {
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "restore cpufreq policy of cpu "+strings.Join(failed, ","), "see the log for details")
+	}
+	if err := exec.RemoveRecord(freqAction, uid); err != nil {
+		log.Warnf(ctx, "remove the original cpufreq policy record failed, %v", err)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+// restore restores the original policy of the cores, returns the cores failed
+func (fe *freqExecutor) restore(ctx context.Context, record *freqRecord) []string {
+	var failed []string
+	for _, state := range record.States {
+		// raise the max frequency before the min frequency, the min must not be bigger than the max
+		for _, kv := range [][2]string{
+			{scalingMaxFreq, state.MaxFreq},
+			{scalingMinFreq, state.MinFreq},
+			{scalingGovernor, state.Governor},
+		} {
+			if err := writeCpufreq(record.SysfsRoot, state.Cpu, kv[0], kv[1]); err != nil {
+				log.Errorf(ctx, "restore %s of cpu%d to %s failed, %v", kv[0], state.Cpu, kv[1], err)
+				failed = append(failed, strconv.Itoa(state.Cpu))
+				break
+			}
+		}
+	}
+	return failed
+}
+
+func cpufreqPath(sysfsRoot string, cpu int, name string) string {
+	return filepath.Join(cpuSysfsPath(sysfsRoot), fmt.Sprintf("cpu%d", cpu), "cpufreq", name)
+}
+
+func readCpufreq(sysfsRoot string, cpu int, name string) (string, error) {
+	bytes, err := os.ReadFile(cpufreqPath(sysfsRoot, cpu, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+func writeCpufreq(sysfsRoot string, cpu int, name, value string) error {
+	return os.WriteFile(cpufreqPath(sysfsRoot, cpu, name), []byte(value), 0644) //nolint:gosec
+}
+
+func snapshotCpufreq(sysfsRoot string, cpu int) (*freqState, error) {
+	state := &freqState{Cpu: cpu}
+	var err error
+	if state.Governor, err = readCpufreq(sysfsRoot, cpu, scalingGovernor); err != nil {
+		return nil, err
+	}
+	if state.MinFreq, err = readCpufreq(sysfsRoot, cpu, scalingMinFreq); err != nil {
+		return nil, err
+	}
+	if state.MaxFreq, err = readCpufreq(sysfsRoot, cpu, scalingMaxFreq); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// applyCpufreq switches the governor and caps the max frequency, the min
+// frequency is lowered first if it is bigger than the cap
+func applyCpufreq(sysfsRoot string, state freqState, governor string, maxFreq int) error {
+	if governor != "" {
+		if err := writeCpufreq(sysfsRoot, state.Cpu, scalingGovernor, governor); err != nil {
+			return err
+		}
+	}
+	if maxFreq <= 0 {
+		return nil
+	}
+	if hwMin, err := readCpufreq(sysfsRoot, state.Cpu, cpuinfoMinFreq); err == nil {
+		if min, _ := strconv.Atoi(hwMin); maxFreq < min {
+			maxFreq = min
+		}
+	}
+	if min, _ := strconv.Atoi(state.MinFreq); min > maxFreq {
+		if err := writeCpufreq(sysfsRoot, state.Cpu, scalingMinFreq, strconv.Itoa(maxFreq)); err != nil {
+			return err
+		}
+	}
+	return writeCpufreq(sysfsRoot, state.Cpu, scalingMaxFreq, strconv.Itoa(maxFreq))
+}
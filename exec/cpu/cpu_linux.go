@@ -18,63 +18,35 @@ package cpu
 
 import (
 	"context"
-	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"strconv"
+
 	"github.com/chaosblade-io/chaosblade-spec-go/channel"
 	"github.com/chaosblade-io/chaosblade-spec-go/log"
-	"github.com/containerd/cgroups"
-	"github.com/shirou/gopsutil/cpu"
-	"strconv"
-	"time"
-)
 
-func getUsed(ctx context.Context, percpu bool, cpuIndex int) float64 {
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+)
 
+// newUsageSampler returns the sampler of the target container cgroup for the nsexec channel,
+// otherwise returns the sampler of the host
+func newUsageSampler(ctx context.Context, percpu bool, cpuIndex int) (usageSampler, error) {
 	pid := ctx.Value(channel.NSTargetFlagName)
-	cpuCount := ctx.Value("cpuCount").(int)
-
-	if pid != nil {
-		p, err := strconv.Atoi(pid.(string))
-		if err != nil {
-			log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
-		}
-
-		cgroupRoot := ctx.Value("cgroup-root")
-		if cgroupRoot == "" {
-			cgroupRoot = "/sys/fs/cgroup/"
-		}
-
-		log.Debugf(ctx, "get cpu useage by cgroup, root path: %s", cgroupRoot)
-
-		cgroup, err := cgroups.Load(exec.Hierarchy(cgroupRoot.(string)), exec.PidPath(p))
-		if err != nil {
-			log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
-		}
-
-		stats, err := cgroup.Stat(cgroups.IgnoreNotExist)
-		if err != nil {
-			log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
-		} else {
-			pre := float64(stats.CPU.Usage.Total) / float64(time.Second)
-			time.Sleep(time.Second)
-			nextStats, err := cgroup.Stat(cgroups.IgnoreNotExist)
-			if err != nil {
-				log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
-			} else {
-				next := float64(nextStats.CPU.Usage.Total) / float64(time.Second)
-				return ((next - pre) * 100) / float64(cpuCount)
-			}
-		}
+	if pid == nil {
+		return newHostSampler(percpu, cpuIndex)
 	}
 
-	totalCpuPercent, err := cpu.Percent(time.Second, percpu)
+	p, err := strconv.Atoi(pid.(string))
 	if err != nil {
-		log.Fatalf(ctx, "get cpu usage fail, %s", err.Error())
+		return nil, err
 	}
-	if percpu {
-		if cpuIndex > len(totalCpuPercent) {
-			log.Fatalf(ctx, "illegal cpu index %d", cpuIndex)
-		}
-		return totalCpuPercent[cpuIndex]
+	cgroupRoot := ctx.Value("cgroup-root")
+	if cgroupRoot == nil || cgroupRoot == "" {
+		cgroupRoot = "/sys/fs/cgroup/"
+	}
+	log.Debugf(ctx, "get cpu useage by cgroup, root path: %s", cgroupRoot)
+
+	cgroup, err := exec.LoadCgroup(cgroupRoot.(string), p)
+	if err != nil {
+		return nil, err
 	}
-	return totalCpuPercent[0]
+	return newCgroupSampler(cgroup, ctx.Value("cpuCount").(int))
 }
@@ -0,0 +1,250 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const offlineAction = "cpu-offline"
+
+type OfflineActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewOfflineActionCommandSpec() spec.ExpActionCommandSpec {
+	return &OfflineActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags:    []spec.ExpFlagSpec{},
+			ActionExecutor: &offlineExecutor{},
+			ActionExample: `
+# Take the cores 2 and 3 offline
+blade create cpu offline --cpu-list 2,3
+
+# Take two cores offline, the cores with the highest index are chosen
+blade create cpu offline --cpu-count 2`,
+			ActionCategories: []string{category.SystemCpu},
+		},
+	}
+}
+
+func (*OfflineActionCommandSpec) Name() string {
+	return "offline"
+}
+
+func (*OfflineActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*OfflineActionCommandSpec) ShortDesc() string {
+	return "cpu offline"
+}
+
+func (o *OfflineActionCommandSpec) LongDesc() string {
+	if o.ActionLongDesc != "" {
+		return o.ActionLongDesc
+	}
+	return "Take cores offline by cpu hotplug, cpu0 and the last online core are never taken offline. The cores are brought back when the experiment is destroyed"
+}
+
+type offlineExecutor struct {
+	channel spec.Channel
+}
+
+func (oe *offlineExecutor) Name() string {
+	return "offline"
+}
+
+func (oe *offlineExecutor) SetChannel(channel spec.Channel) {
+	oe.channel = channel
+}
+
+// offlineRecord is the cores taken offline by the experiment
+type offlineRecord struct {
+	SysfsRoot string `json:"sysfsRoot"`
+	Cpus      []int  `json:"cpus"`
+}
+
+func (oe *offlineExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if oe.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return oe.stop(ctx, uid)
+	}
+
+	sysfsRoot := model.ActionFlags["sysfs-root"]
+	var cpuList []int
+	var cpuCount int
+	cpuListStr := model.ActionFlags["cpu-list"]
+	cpuCountStr := model.ActionFlags["cpu-count"]
+	if cpuListStr != "" {
+		cores, err := util.ParseIntegerListToStringSlice("cpu-list", cpuListStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuListStr, err.Error())
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
+		}
+		for _, core := range cores {
+			c, _ := strconv.Atoi(core)
+			cpuList = append(cpuList, c)
+		}
+	} else if cpuCountStr != "" {
+		var err error
+		cpuCount, err = strconv.Atoi(cpuCountStr)
+		if err != nil || cpuCount <= 0 {
+			log.Errorf(ctx, "`%s`: cpu-count is illegal, cpu-count value must be a positive integer", cpuCountStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-count", cpuCountStr, "it must be a positive integer")
+		}
+	} else {
+		log.Errorf(ctx, "cpu-list or cpu-count is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "cpu-list|cpu-count")
+	}
+
+	online, err := onlineCpus(sysfsRoot)
+	if err != nil {
+		log.Errorf(ctx, "get online cpus failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get online cpus", err)
+	}
+	cpus, err := selectOfflineCpus(online, cpuList, cpuCount)
+	if err != nil {
+		log.Errorf(ctx, "select cpus to offline failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list|cpu-count", cpuListStr+cpuCountStr, err)
+	}
+	return oe.start(ctx, uid, sysfsRoot, cpus)
+}
+
+func (oe *offlineExecutor) start(ctx context.Context, uid, sysfsRoot string, cpus []int) *spec.Response {
+	if err := exec.SaveRecord(offlineAction, uid, &offlineRecord{SysfsRoot: sysfsRoot, Cpus: cpus}); err != nil {
+		log.Errorf(ctx, "save the offline cpus failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the offline cpus", err)
+	}
+	for i, cpu := range cpus {
+		if err := setCpuOnline(sysfsRoot, cpu, false); err != nil {
+			log.Errorf(ctx, "offline cpu%d failed, %v", cpu, err)
+			// bring back the cores already taken offline
+			for _, c := range cpus[:i] {
+				if err := setCpuOnline(sysfsRoot, c, true); err != nil {
+					log.Errorf(ctx, "online cpu%d failed, %v", c, err)
+				}
+			}
+			exec.RemoveRecord(offlineAction, uid)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, fmt.Sprintf("offline cpu%d", cpu), err)
+		}
+		log.Infof(ctx, "cpu%d is offline", cpu)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func (oe *offlineExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record offlineRecord
+	if err := exec.LoadRecord(offlineAction, uid, &record); err != nil {
+		log.Errorf(ctx, "load the offline cpus failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the offline cpus", err)
+	}
+	var failed []string
+	for _, cpu := range record.Cpus {
+		if err := setCpuOnline(record.SysfsRoot, cpu, true); err != nil {
+			log.Errorf(ctx, "online cpu%d failed, %v", cpu, err)
+			failed = append(failed, strconv.Itoa(cpu))
+			continue
+		}
+		log.Infof(ctx, "cpu%d is online", cpu)
+	}
+	if len(failed) > 0 {
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "online cpu "+strings.Join(failed, ","), "see the log for details")
+	}
+	if err := exec.RemoveRecord(offlineAction, uid); err != nil {
+		log.Warnf(ctx, "remove the offline cpus record failed, %v", err)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func cpuSysfsPath(sysfsRoot string) string {
+	if sysfsRoot == "" {
+		sysfsRoot = "/sys"
+	}
+	return filepath.Join(sysfsRoot, "devices/system/cpu")
+}
+
+// onlineCpus returns the online cores from devices/system/cpu/online, such as 0-3,5
+func onlineCpus(sysfsRoot string) ([]int, error) {
+	return readCpuList(filepath.Join(cpuSysfsPath(sysfsRoot), "online"))
+}
+
+func setCpuOnline(sysfsRoot string, cpu int, online bool) error {
+	value := "0"
+	if online {
+		value = "1"
+	}
+	return os.WriteFile(filepath.Join(cpuSysfsPath(sysfsRoot), fmt.Sprintf("cpu%d", cpu), "online"), []byte(value), 0644) //nolint:gosec
+}
+
+// selectOfflineCpus returns the cores to take offline, cpu0 and the last online core are never chosen.
+// If the cpuList is empty, the cpuCount cores with the highest index are chosen.
+func selectOfflineCpus(online []int, cpuList []int, cpuCount int) ([]int, error) {
+	isOnline := make(map[int]bool, len(online))
+	for _, cpu := range online {
+		isOnline[cpu] = true
+	}
+	var cpus []int
+	if len(cpuList) > 0 {
+		seen := make(map[int]bool, len(cpuList))
+		for _, cpu := range cpuList {
+			if seen[cpu] {
+				continue
+			}
+			seen[cpu] = true
+			if cpu == 0 {
+				return nil, fmt.Errorf("cpu0 cannot be taken offline")
+			}
+			if !isOnline[cpu] {
+				return nil, fmt.Errorf("cpu%d is not online", cpu)
+			}
+			cpus = append(cpus, cpu)
+		}
+	} else {
+		sorted := append([]int{}, online...)
+		sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+		for _, cpu := range sorted {
+			if len(cpus) == cpuCount || cpu == 0 {
+				break
+			}
+			cpus = append(cpus, cpu)
+		}
+		if len(cpus) < cpuCount {
+			return nil, fmt.Errorf("only %d cores except cpu0 are online", len(cpus))
+		}
+	}
+	if len(cpus) >= len(online) {
+		return nil, fmt.Errorf("the last online core cannot be taken offline")
+	}
+	return cpus, nil
+}
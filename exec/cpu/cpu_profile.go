@@ -0,0 +1,150 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+)
+
+const (
+	ProfileConstant = "constant"
+	ProfileSine     = "sine"
+	ProfileStep     = "step"
+	ProfileSawtooth = "sawtooth"
+	ProfileSquare   = "square"
+)
+
+// loadProfile changes the target cpu percent periodically
+type loadProfile struct {
+	kind   string
+	period int
+	min    float64
+	max    float64
+	steps  int
+}
+
+// parseLoadProfile parses the profile flags, returns nil if the profile is constant
+func parseLoadProfile(ctx context.Context, flags map[string]string, cpuPercent int) (*loadProfile, *spec.Response) {
+	kind := flags["profile"]
+	if kind == "" || kind == ProfileConstant {
+		return nil, nil
+	}
+	if kind != ProfileSine && kind != ProfileStep && kind != ProfileSawtooth && kind != ProfileSquare {
+		log.Errorf(ctx, "`%s`: profile is illegal, it must be one of constant, sine, step, sawtooth and square", kind)
+		return nil, spec.ResponseFailWithFlags(spec.ParameterIllegal, "profile", kind, "it must be one of constant, sine, step, sawtooth and square")
+	}
+	p := &loadProfile{kind: kind, min: 0, max: float64(cpuPercent), steps: 4}
+
+	var resp *spec.Response
+	if p.period, resp = parseProfileInt(ctx, flags, "profile-period", 60, 1, math.MaxInt32); resp != nil {
+		return nil, resp
+	}
+	if p.steps, resp = parseProfileInt(ctx, flags, "profile-steps", p.steps, 2, 100); resp != nil {
+		return nil, resp
+	}
+	if flags["profile-amplitude"] != "" {
+		amplitude, resp := parseProfileInt(ctx, flags, "profile-amplitude", 0, 0, 100)
+		if resp != nil {
+			return nil, resp
+		}
+		p.min = math.Max(float64(cpuPercent-amplitude), 0)
+		p.max = math.Min(float64(cpuPercent+amplitude), 100)
+	}
+	if flags["profile-min"] != "" {
+		min, resp := parseProfileInt(ctx, flags, "profile-min", 0, 0, 100)
+		if resp != nil {
+			return nil, resp
+		}
+		p.min = float64(min)
+	}
+	if flags["profile-max"] != "" {
+		max, resp := parseProfileInt(ctx, flags, "profile-max", 0, 0, 100)
+		if resp != nil {
+			return nil, resp
+		}
+		p.max = float64(max)
+	}
+	if p.min > p.max {
+		log.Errorf(ctx, "`%v`: profile-min is illegal, it must not be bigger than profile-max %v", p.min, p.max)
+		return nil, spec.ResponseFailWithFlags(spec.ParameterIllegal, "profile-min", p.min, "it must not be bigger than profile-max")
+	}
+	return p, nil
+}
+
+func parseProfileInt(ctx context.Context, flags map[string]string, name string, defaultValue, min, max int) (int, *spec.Response) {
+	valueStr := flags[name]
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < min || value > max {
+		log.Errorf(ctx, "`%s`: %s is illegal, it must be an integer between %d and %d", valueStr, name, min, max)
+		return 0, spec.ResponseFailWithFlags(spec.ParameterIllegal, name, valueStr,
+			fmt.Sprintf("it must be an integer between %d and %d", min, max))
+	}
+	return value, nil
+}
+
+// percent returns the target cpu percent after the elapsed seconds
+func (p *loadProfile) percent(elapsed float64) float64 {
+	phase := math.Mod(elapsed, float64(p.period)) / float64(p.period)
+	amplitude := p.max - p.min
+	switch p.kind {
+	case ProfileSine:
+		return p.min + amplitude*(1-math.Cos(2*math.Pi*phase))/2
+	case ProfileStep:
+		return p.min + amplitude*math.Floor(phase*float64(p.steps))/float64(p.steps-1)
+	case ProfileSawtooth:
+		return p.min + amplitude*phase
+	case ProfileSquare:
+		if phase < 0.5 {
+			return p.min
+		}
+		return p.max
+	}
+	return p.max
+}
+
+// args returns the profile flags passed to the burn process of each core
+func (p *loadProfile) args() string {
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf(" --profile %s --profile-period %d --profile-min %d --profile-max %d --profile-steps %d",
+		p.kind, p.period, int(p.min), int(p.max), p.steps)
+}
+
+// drive updates the slope percent every second according to the profile
+func (p *loadProfile) drive(ctx context.Context, slopePercent *atomicFloat) {
+	start := time.Now()
+	slopePercent.store(p.percent(0))
+	var ticker = time.NewTicker(time.Second)
+	go func() {
+		for range ticker.C {
+			percent := p.percent(time.Since(start).Seconds())
+			slopePercent.store(percent)
+			log.Debugf(ctx, "cpu profile %s, target percent: %f", p.kind, percent)
+		}
+	}()
+}
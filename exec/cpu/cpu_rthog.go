@@ -0,0 +1,239 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const (
+	PolicyFifo = "fifo"
+	PolicyRR   = "rr"
+)
+
+const (
+	// maxRtDuty is the hard cap of the duty cycle, the core is never monopolized
+	maxRtDuty = 95
+	// rtPeriod is the busy+idle cycle of the real-time spinner
+	rtPeriod = 100 * time.Millisecond
+)
+
+type RtHogActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewRtHogActionCommandSpec() spec.ExpActionCommandSpec {
+	return &RtHogActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "policy",
+					Desc: "The real-time scheduling policy, fifo or rr, default value is fifo",
+				},
+				&spec.ExpFlag{
+					Name: "priority",
+					Desc: "The real-time priority (1-99), default value is 50",
+				},
+				&spec.ExpFlag{
+					Name: "duty",
+					Desc: fmt.Sprintf("Percent of each %s period the spinner runs (1-%d), default value is 80", rtPeriod, maxRtDuty),
+				},
+			},
+			ActionExecutor: &rtHogExecutor{},
+			ActionExample: `
+# Starve the normal tasks on core 1 with a SCHED_FIFO spinner running 80% of the time
+blade create cpu rt-hog --cpu-list 1
+
+# Run SCHED_RR spinners with priority 10 and 50% duty on the cores 2 and 3
+blade create cpu rt-hog --cpu-list 2,3 --policy rr --priority 10 --duty 50`,
+			ActionCategories:  []string{category.SystemCpu},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*RtHogActionCommandSpec) Name() string {
+	return "rt-hog"
+}
+
+func (*RtHogActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*RtHogActionCommandSpec) ShortDesc() string {
+	return "real-time cpu hog"
+}
+
+func (r *RtHogActionCommandSpec) LongDesc() string {
+	if r.ActionLongDesc != "" {
+		return r.ActionLongDesc
+	}
+	return fmt.Sprintf("Run a SCHED_FIFO or SCHED_RR spinner pinned to each core of the cpu-list to starve the normal priority tasks, "+
+		"kernel threads and watchdogs. The duty cycle is never bigger than %d%%, and at least one online core is never hogged. "+
+		"The spinners are switched back to SCHED_OTHER and killed when the experiment is destroyed", maxRtDuty)
+}
+
+type rtHogExecutor struct {
+	channel spec.Channel
+}
+
+func (re *rtHogExecutor) Name() string {
+	return "rt-hog"
+}
+
+func (re *rtHogExecutor) SetChannel(channel spec.Channel) {
+	re.channel = channel
+}
+
+func (re *rtHogExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if re.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return re.stop(ctx)
+	}
+
+	policy := model.ActionFlags["policy"]
+	if policy == "" {
+		policy = PolicyFifo
+	}
+	if policy != PolicyFifo && policy != PolicyRR {
+		log.Errorf(ctx, "`%s`: policy is illegal, it must be fifo or rr", policy)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "policy", policy, "it must be fifo or rr")
+	}
+
+	priority := 50
+	if priorityStr := model.ActionFlags["priority"]; priorityStr != "" {
+		var err error
+		priority, err = strconv.Atoi(priorityStr)
+		if err != nil || priority < 1 || priority > 99 {
+			log.Errorf(ctx, "`%s`: priority is illegal, it must be an integer between 1 and 99", priorityStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "priority", priorityStr, "it must be an integer between 1 and 99")
+		}
+	}
+
+	duty := 80
+	if dutyStr := model.ActionFlags["duty"]; dutyStr != "" {
+		var err error
+		duty, err = strconv.Atoi(dutyStr)
+		if err != nil || duty < 1 || duty > maxRtDuty {
+			log.Errorf(ctx, "`%s`: duty is illegal, it must be an integer between 1 and %d", dutyStr, maxRtDuty)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "duty", dutyStr, fmt.Sprintf("it must be an integer between 1 and %d", maxRtDuty))
+		}
+	}
+
+	// the process pinned to a core by taskset runs the spinner
+	if cpuIndex := model.ActionFlags["cpu-index"]; cpuIndex != "" {
+		return re.hog(ctx, cpuIndex, policy, priority, duty)
+	}
+
+	cpuListStr := model.ActionFlags["cpu-list"]
+	if cpuListStr == "" {
+		log.Errorf(ctx, "cpu-list is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "cpu-list")
+	}
+	if !re.channel.IsCommandAvailable(ctx, "taskset") {
+		return spec.ResponseFailWithFlags(spec.CommandTasksetNotFound)
+	}
+	cores, err := util.ParseIntegerListToStringSlice("cpu-list", cpuListStr)
+	if err != nil {
+		log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuListStr, err.Error())
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
+	}
+	cores = util.RemoveDuplicates(cores)
+	online, err := onlineCpus(model.ActionFlags["sysfs-root"])
+	if err != nil {
+		log.Errorf(ctx, "get online cpus failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get online cpus", err)
+	}
+	if err := checkRtHogCores(online, cores); err != nil {
+		log.Errorf(ctx, "`%s`: cpu-list is illegal, %v", cpuListStr, err)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
+	}
+	return re.start(ctx, cores, policy, priority, duty)
+}
+
+// start runs a process pinned to each core by taskset, the same as cpu fullload
+func (re *rtHogExecutor) start(ctx context.Context, cores []string, policy string, priority, duty int) *spec.Response {
+	for _, core := range cores {
+		args := fmt.Sprintf(`create cpu rt-hog --policy %s --priority %d --duty %d --cpu-index %s --uid %s`,
+			policy, priority, duty, core, ctx.Value(spec.Uid))
+		if resp := exec.StartPinned(ctx, core, args); resp != nil {
+			return resp
+		}
+	}
+	return spec.ReturnSuccess(ctx.Value(spec.Uid))
+}
+
+// hog spins with the real-time policy on the current thread
+func (re *rtHogExecutor) hog(ctx context.Context, cpuIndex, policy string, priority, duty int) *spec.Response {
+	if runtime, err := os.ReadFile("/proc/sys/kernel/sched_rt_runtime_us"); err == nil {
+		log.Infof(ctx, "sched_rt_runtime_us: %s", strings.TrimSpace(string(runtime)))
+	}
+	log.Infof(ctx, "cpu%s is hogged, policy: %s, priority: %d, duty: %d%%", cpuIndex, policy, priority, duty)
+	if err := rtSpin(policy, priority, duty); err != nil {
+		log.Errorf(ctx, "set real-time policy failed, %v", err)
+		return spec.ReturnFail(spec.OsCmdExecFailed, fmt.Sprintf("set real-time policy failed, %v", err))
+	}
+	return spec.ReturnSuccess(ctx.Value(spec.Uid))
+}
+
+// stop switches the spinners back to SCHED_OTHER before killing them,
+// so that the kill is not delayed by the hogged cores
+func (re *rtHogExecutor) stop(ctx context.Context) *spec.Response {
+	suid := ctx.Value(spec.Uid)
+	if suid != nil && suid != spec.UnknownUid && suid != "" {
+		pids, _ := re.channel.GetPidsByProcessName("chaos_os", context.WithValue(ctx, channel.ProcessKey, suid))
+		for _, pid := range pids {
+			if err := resetScheduler(pid); err != nil {
+				log.Warnf(ctx, "reset the scheduling policy of pid %s failed, %v", pid, err)
+			}
+		}
+	}
+	return exec.Destroy(ctx, re.channel, "cpu rt-hog")
+}
+
+// checkRtHogCores makes sure the cores are online and at least one online core is not hogged
+func checkRtHogCores(online []int, cores []string) error {
+	isOnline := make(map[string]bool, len(online))
+	for _, cpu := range online {
+		isOnline[strconv.Itoa(cpu)] = true
+	}
+	for _, core := range cores {
+		if !isOnline[core] {
+			return fmt.Errorf("cpu%s is not online", core)
+		}
+	}
+	if len(cores) >= len(online) {
+		return fmt.Errorf("at least one online core must not be hogged")
+	}
+	return nil
+}
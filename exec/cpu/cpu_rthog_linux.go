@@ -0,0 +1,87 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	schedOther = 0
+	schedFifo  = 1
+	schedRR    = 2
+)
+
+// setScheduler sets the policy of the thread, tid 0 means the calling thread
+func setScheduler(tid, policy, priority int) error {
+	param := struct{ priority int32 }{int32(priority)}
+	_, _, errno := syscall.Syscall(syscall.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// rtSpin locks the goroutine to the thread, switches the thread to the real-time
+// policy and spins for duty percent of each period. The idle part is slept by
+// nanosleep in the thread, so that no other thread is needed to wake it up.
+func rtSpin(policy string, priority, duty int) error {
+	runtime.LockOSThread()
+	p := schedFifo
+	if policy == PolicyRR {
+		p = schedRR
+	}
+	if err := setScheduler(0, p, priority); err != nil {
+		return err
+	}
+	busy := rtPeriod * time.Duration(duty) / 100
+	idle := syscall.NsecToTimespec(int64(rtPeriod - busy))
+	for {
+		start := time.Now()
+		for time.Since(start) < busy {
+		}
+		ts := idle
+		for syscall.Nanosleep(&ts, &ts) == syscall.EINTR {
+		}
+	}
+}
+
+// resetScheduler switches all threads of the process to SCHED_OTHER
+func resetScheduler(pid string) error {
+	tasks, err := os.ReadDir(filepath.Join("/proc", pid, "task"))
+	if err != nil {
+		return err
+	}
+	var failed error
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := setScheduler(tid, schedOther, 0); err != nil {
+			failed = fmt.Errorf("thread %d: %v", tid, err)
+		}
+	}
+	return failed
+}
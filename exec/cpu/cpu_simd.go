@@ -0,0 +1,33 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import "math"
+
+// simdLanes is the number of float64 accumulators of the fma kernel, they fill 8 ymm registers
+// of amd64 or 16 q registers of arm64, so that the fused multiply-adds are independent
+const simdLanes = 32
+
+// fmaScalar is the fallback and the reference of the vector kernel, the product x*m
+// is added to each accumulator for n rounds
+func fmaScalar(acc *[simdLanes]float64, x, m float64, n int) {
+	for i := 0; i < n; i++ {
+		for j := range acc {
+			acc[j] = math.FMA(x, m, acc[j])
+		}
+	}
+}
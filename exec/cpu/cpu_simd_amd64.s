@@ -0,0 +1,56 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#include "textflag.h"
+
+// func fmaAVX2(acc *[32]float64, x, m float64, n int)
+TEXT ·fmaAVX2(SB), NOSPLIT, $0-32
+	MOVQ         acc+0(FP), DI
+	VBROADCASTSD x+8(FP), Y8
+	VBROADCASTSD m+16(FP), Y9
+	MOVQ         n+24(FP), CX
+	VMOVUPD      0(DI), Y0
+	VMOVUPD      32(DI), Y1
+	VMOVUPD      64(DI), Y2
+	VMOVUPD      96(DI), Y3
+	VMOVUPD      128(DI), Y4
+	VMOVUPD      160(DI), Y5
+	VMOVUPD      192(DI), Y6
+	VMOVUPD      224(DI), Y7
+
+loop:
+	// yN = y8*y9 + yN, the 8 chains are independent to fill the fma pipelines
+	VFMADD231PD Y9, Y8, Y0
+	VFMADD231PD Y9, Y8, Y1
+	VFMADD231PD Y9, Y8, Y2
+	VFMADD231PD Y9, Y8, Y3
+	VFMADD231PD Y9, Y8, Y4
+	VFMADD231PD Y9, Y8, Y5
+	VFMADD231PD Y9, Y8, Y6
+	VFMADD231PD Y9, Y8, Y7
+	DECQ        CX
+	JNZ         loop
+
+	VMOVUPD Y0, 0(DI)
+	VMOVUPD Y1, 32(DI)
+	VMOVUPD Y2, 64(DI)
+	VMOVUPD Y3, 96(DI)
+	VMOVUPD Y4, 128(DI)
+	VMOVUPD Y5, 160(DI)
+	VMOVUPD Y6, 192(DI)
+	VMOVUPD Y7, 224(DI)
+	VZEROUPPER
+	RET
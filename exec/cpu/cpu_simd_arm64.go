@@ -0,0 +1,31 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+// hasVectorFMA is true if the fma kernel runs on the vector units, neon is mandatory on arm64
+const hasVectorFMA = true
+
+//go:noescape
+func fmaNEON(acc *[simdLanes]float64, x, m float64, n int)
+
+// fmaVector runs the fma kernel by fmla of neon
+func fmaVector(acc *[simdLanes]float64, x, m float64, n int) {
+	if n <= 0 {
+		return
+	}
+	fmaNEON(acc, x, m, n)
+}
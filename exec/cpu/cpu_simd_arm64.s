@@ -0,0 +1,59 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#include "textflag.h"
+
+// func fmaNEON(acc *[32]float64, x, m float64, n int)
+TEXT ·fmaNEON(SB), NOSPLIT, $0-32
+	MOVD  acc+0(FP), R0
+	FMOVD x+8(FP), F16
+	FMOVD m+16(FP), F17
+	MOVD  n+24(FP), R2
+	VDUP  V16.D[0], V16.D2
+	VDUP  V17.D[0], V17.D2
+	MOVD  R0, R1
+	VLD1.P 64(R1), [V0.D2, V1.D2, V2.D2, V3.D2]
+	VLD1.P 64(R1), [V4.D2, V5.D2, V6.D2, V7.D2]
+	VLD1.P 64(R1), [V8.D2, V9.D2, V10.D2, V11.D2]
+	VLD1   (R1), [V12.D2, V13.D2, V14.D2, V15.D2]
+
+loop:
+	// vN = vN + v16*v17, the 16 chains are independent to fill the fma pipelines
+	VFMLA V17.D2, V16.D2, V0.D2
+	VFMLA V17.D2, V16.D2, V1.D2
+	VFMLA V17.D2, V16.D2, V2.D2
+	VFMLA V17.D2, V16.D2, V3.D2
+	VFMLA V17.D2, V16.D2, V4.D2
+	VFMLA V17.D2, V16.D2, V5.D2
+	VFMLA V17.D2, V16.D2, V6.D2
+	VFMLA V17.D2, V16.D2, V7.D2
+	VFMLA V17.D2, V16.D2, V8.D2
+	VFMLA V17.D2, V16.D2, V9.D2
+	VFMLA V17.D2, V16.D2, V10.D2
+	VFMLA V17.D2, V16.D2, V11.D2
+	VFMLA V17.D2, V16.D2, V12.D2
+	VFMLA V17.D2, V16.D2, V13.D2
+	VFMLA V17.D2, V16.D2, V14.D2
+	VFMLA V17.D2, V16.D2, V15.D2
+	SUBS  $1, R2, R2
+	BNE   loop
+
+	MOVD   R0, R1
+	VST1.P [V0.D2, V1.D2, V2.D2, V3.D2], 64(R1)
+	VST1.P [V4.D2, V5.D2, V6.D2, V7.D2], 64(R1)
+	VST1.P [V8.D2, V9.D2, V10.D2, V11.D2], 64(R1)
+	VST1   [V12.D2, V13.D2, V14.D2, V15.D2], (R1)
+	RET
@@ -0,0 +1,27 @@
+//go:build !amd64 && !arm64
+
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+// hasVectorFMA is true if the fma kernel runs on the vector units
+const hasVectorFMA = false
+
+// fmaVector falls back to the scalar kernel, the vector kernel is only implemented for amd64 and arm64
+func fmaVector(acc *[simdLanes]float64, x, m float64, n int) {
+	fmaScalar(acc, x, m, n)
+}
@@ -17,9 +17,22 @@
 package cpu
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
 	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
 )
 
 func TestParseCpuList(t *testing.T) {
@@ -46,3 +59,466 @@ func TestParseCpuList(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadProfilePercent(t *testing.T) {
+	tests := []struct {
+		profile loadProfile
+		elapsed float64
+		expect  float64
+	}{
+		{loadProfile{kind: ProfileSine, period: 60, min: 20, max: 80}, 0, 20},
+		{loadProfile{kind: ProfileSine, period: 60, min: 20, max: 80}, 30, 80},
+		{loadProfile{kind: ProfileSquare, period: 600, min: 20, max: 80}, 299, 20},
+		{loadProfile{kind: ProfileSquare, period: 600, min: 20, max: 80}, 300, 80},
+		{loadProfile{kind: ProfileSawtooth, period: 100, min: 0, max: 100}, 150, 50},
+		{loadProfile{kind: ProfileStep, period: 40, min: 20, max: 80, steps: 4}, 0, 20},
+		{loadProfile{kind: ProfileStep, period: 40, min: 20, max: 80, steps: 4}, 15, 40},
+		{loadProfile{kind: ProfileStep, period: 40, min: 20, max: 80, steps: 4}, 39, 80},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.percent(tt.elapsed); math.Abs(got-tt.expect) > 1e-9 {
+			t.Errorf("unexpected %s percent at %vs: %v, expected: %v", tt.profile.kind, tt.elapsed, got, tt.expect)
+		}
+	}
+}
+
+func TestNewWorkload(t *testing.T) {
+	tests := []struct {
+		kind string
+		ok   bool
+	}{
+		{"", true},
+		{WorkloadSpin, true},
+		{WorkloadInteger, true},
+		{WorkloadFloat, true},
+		{WorkloadSimd, true},
+		{WorkloadCache, true},
+		{WorkloadBranch, true},
+		{"avx", false},
+		{"Spin", false},
+		{" spin", false},
+	}
+	for _, tt := range tests {
+		work, ok := newWorkload(tt.kind)
+		if ok != tt.ok || (work != nil) != tt.ok {
+			t.Errorf("newWorkload(%q) = %v, %v, expected %v", tt.kind, work != nil, ok, tt.ok)
+			continue
+		}
+		// the chain of the cache workload is too big to build in the unit test
+		if work != nil && tt.kind != WorkloadCache {
+			work()
+		}
+	}
+}
+
+func TestFmaVector(t *testing.T) {
+	t.Logf("vector fma: %v", hasVectorFMA)
+	for _, n := range []int{0, 1, 7, 4096} {
+		var expected, acc [simdLanes]float64
+		for i := range acc {
+			acc[i] = float64(i) * 0.5
+			expected[i] = acc[i]
+		}
+		fmaScalar(&expected, 1e-3, 1e-9, n)
+		fmaVector(&acc, 1e-3, 1e-9, n)
+		if acc != expected {
+			t.Errorf("%d rounds: expected %v, got %v", n, expected, acc)
+		}
+	}
+}
+
+func TestParseCpuListWithPercent(t *testing.T) {
+	got, err := parseCpuList("0:90,1:50,2-3:20,5", 60)
+	if err != nil {
+		t.Fatalf("input is illegal, %v", err)
+	}
+	expect := []corePercent{{"0", 90}, {"1", 50}, {"2", 20}, {"3", 20}, {"5", 60}}
+	if len(got) != len(expect) {
+		t.Fatalf("expected to see %d cpu, got %d", len(expect), len(got))
+	}
+	for i, cp := range expect {
+		if got[i] != cp {
+			t.Errorf("unexpected result: %v, expected: %v", got[i], cp)
+		}
+	}
+	for _, input := range []string{"0:101", "0:a", "0-1,1:20"} {
+		if _, err := parseCpuList(input, 100); err == nil {
+			t.Errorf("expected error for %s", input)
+		}
+	}
+}
+
+func TestOfflineCpus(t *testing.T) {
+	root := t.TempDir()
+	cpuPath := cpuSysfsPath(root)
+	for i := 0; i < 4; i++ {
+		if err := os.MkdirAll(filepath.Join(cpuPath, fmt.Sprintf("cpu%d", i)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(cpuPath, "online"), []byte("0-3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	online, err := onlineCpus(root)
+	if err != nil || len(online) != 4 {
+		t.Fatalf("unexpected online cpus: %v, %v", online, err)
+	}
+
+	cpus, err := selectOfflineCpus(online, nil, 2)
+	if err != nil || len(cpus) != 2 || cpus[0] != 3 || cpus[1] != 2 {
+		t.Errorf("unexpected offline cpus: %v, %v", cpus, err)
+	}
+	if _, err := selectOfflineCpus(online, nil, 4); err == nil {
+		t.Errorf("expected error when offline cpu0")
+	}
+	if _, err := selectOfflineCpus(online, []int{0, 1}, 0); err == nil {
+		t.Errorf("expected error when offline cpu0")
+	}
+	if _, err := selectOfflineCpus([]int{0, 2}, []int{1}, 0); err == nil {
+		t.Errorf("expected error when offline a core not online")
+	}
+
+	if err := setCpuOnline(root, 3, false); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := os.ReadFile(filepath.Join(cpuPath, "cpu3", "online")); string(value) != "0" {
+		t.Errorf("unexpected cpu3 online value: %s", value)
+	}
+}
+
+func TestApplyAndRestoreCpufreq(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		scalingGovernor: "performance",
+		scalingMinFreq:  "2000000",
+		scalingMaxFreq:  "3000000",
+		cpuinfoMinFreq:  "800000",
+	}
+	if err := os.MkdirAll(filepath.Dir(cpufreqPath(root, 1, scalingGovernor)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range files {
+		if err := writeCpufreq(root, 1, name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := snapshotCpufreq(root, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCpufreq(root, *state, "powersave", 1200000); err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{scalingGovernor: "powersave", scalingMinFreq: "1200000", scalingMaxFreq: "1200000"} {
+		if got, _ := readCpufreq(root, 1, name); got != expect {
+			t.Errorf("unexpected %s: %s, expected: %s", name, got, expect)
+		}
+	}
+
+	fe := &freqExecutor{}
+	if failed := fe.restore(context.Background(), &freqRecord{SysfsRoot: root, States: []freqState{*state}}); len(failed) > 0 {
+		t.Fatalf("restore failed: %v", failed)
+	}
+	for _, name := range []string{scalingGovernor, scalingMinFreq, scalingMaxFreq} {
+		if got, _ := readCpufreq(root, 1, name); got != files[name] {
+			t.Errorf("unexpected %s: %s, expected: %s", name, got, files[name])
+		}
+	}
+}
+
+func TestCpuControllerConverges(t *testing.T) {
+	// the plant: the burner adds duty*gain*100 percent over a 30% background load
+	gain := 0.5
+	controller := newCpuController(gain)
+	used, duty := 30.0, 0.0
+	for i := 0; i < 100; i++ {
+		duty = controller.update(60-used, sampleInterval.Seconds())
+		used = 30 + duty*gain*100
+	}
+	if math.Abs(used-60) > 0.5 {
+		t.Errorf("expected the usage to converge to 60, got %v", used)
+	}
+
+	// the target cannot be reached, the integral must not wind up
+	for i := 0; i < 100; i++ {
+		duty = controller.update(100-(30+duty*gain*100), sampleInterval.Seconds())
+	}
+	if duty != 1 || controller.integral > 1 {
+		t.Errorf("unexpected duty %v and integral %v when saturated", duty, controller.integral)
+	}
+	duty = controller.update(60-(30+duty*gain*100), sampleInterval.Seconds())
+	if duty >= 1 {
+		t.Errorf("expected the duty to drop immediately after saturation, got %v", duty)
+	}
+}
+
+func TestUsagePercent(t *testing.T) {
+	tests := []struct {
+		used, elapsed time.Duration
+		cpuCount      int
+		expected      float64
+	}{
+		{100 * time.Millisecond, 200 * time.Millisecond, 1, 50},
+		{300 * time.Millisecond, 200 * time.Millisecond, 2, 75},
+		{0, 200 * time.Millisecond, 4, 0},
+		{100 * time.Millisecond, 0, 1, 0},
+		{100 * time.Millisecond, 200 * time.Millisecond, 0, 0},
+	}
+	for _, tt := range tests {
+		if percent := usagePercent(tt.used, tt.elapsed, tt.cpuCount); percent != tt.expected {
+			t.Errorf("usagePercent(%v, %v, %d) = %v, expected %v", tt.used, tt.elapsed, tt.cpuCount, percent, tt.expected)
+		}
+	}
+}
+
+func TestSelfSampler(t *testing.T) {
+	// 500ms of the cpu time in the last second, only the burn process itself is counted
+	for _, cpuCount := range []int{1, 2} {
+		s := newSelfSampler(cpuCount)
+		s.prev -= 500 * time.Millisecond
+		s.prevTime = s.prevTime.Add(-time.Second)
+		used, err := s.sample()
+		if expected := 50 / float64(cpuCount); err != nil || used < expected || used > expected+5 {
+			t.Errorf("%d cpus: expected about %v%%, got %v, %v", cpuCount, expected, used, err)
+		}
+	}
+	// the counter never goes backwards
+	s := newSelfSampler(1)
+	s.prev += time.Hour
+	if used, err := s.sample(); err != nil || used != 0 {
+		t.Errorf("expected 0 for a decreased cpu time, got %v, %v", used, err)
+	}
+}
+
+func TestControlGain(t *testing.T) {
+	ctx := context.Background()
+	host := float64(1) / float64(runtime.NumCPU())
+	if gain := controlGain(ctx, 1, false, ModeRelative); gain != host {
+		t.Errorf("expected the host gain %v, got %v", host, gain)
+	}
+	if gain := controlGain(ctx, 1, true, ModeRelative); gain != 1 {
+		t.Errorf("expected the per-cpu gain 1, got %v", gain)
+	}
+	if gain := controlGain(ctx, 1, false, ModeAbsolute); gain != 1 {
+		t.Errorf("expected the absolute gain 1, got %v", gain)
+	}
+	if gain := controlGain(context.WithValue(ctx, channel.NSTargetFlagName, "1"), 1, false, ModeRelative); gain != 1 {
+		t.Errorf("expected the container gain 1, got %v", gain)
+	}
+
+	// the relative mode tops the 30% background load up by the half of the burned cores,
+	// the absolute mode burns 40% itself whatever the background load is
+	for _, mode := range []string{ModeRelative, ModeAbsolute} {
+		gain := controlGain(ctx, 1, false, mode)
+		target, expected := 40.0, 0.4
+		if mode == ModeRelative {
+			target, expected = 30+50*gain, 0.5
+		}
+		controller := newCpuController(gain)
+		used, duty := 0.0, 0.0
+		for i := 0; i < 200; i++ {
+			duty = controller.update(target-used, sampleInterval.Seconds())
+			if mode == ModeAbsolute {
+				used = duty * 100
+			} else {
+				used = 30 + duty*gain*100
+			}
+		}
+		if math.Abs(used-target) > 0.5 || math.Abs(duty-expected) > 0.01 {
+			t.Errorf("%s: expected the usage %v with the duty %v, got %v with %v", mode, target, expected, used, duty)
+		}
+	}
+}
+
+func TestContextSwitchToken(t *testing.T) {
+	for _, mechanism := range []string{MechanismFutex, MechanismPipe} {
+		tk, err := newToken(mechanism)
+		if err != nil {
+			t.Logf("skip %s, %v", mechanism, err)
+			continue
+		}
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 1000; i++ {
+				if err := tk.wait(1); err != nil {
+					t.Error(err)
+				}
+				if err := tk.pass(1); err != nil {
+					t.Error(err)
+				}
+			}
+			close(done)
+		}()
+		for i := 0; i < 1000; i++ {
+			if err := tk.pass(0); err != nil {
+				t.Fatal(err)
+			}
+			if err := tk.wait(0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		<-done
+	}
+}
+
+func TestCheckRtHogCores(t *testing.T) {
+	online := []int{0, 1, 2, 3}
+	if err := checkRtHogCores(online, []string{"1", "3"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkRtHogCores(online, []string{"0", "1", "2", "3"}); err == nil {
+		t.Errorf("expected error when every core is hogged")
+	}
+	if err := checkRtHogCores(online, []string{"4"}); err == nil {
+		t.Errorf("expected error for the offline core")
+	}
+}
+
+func TestResolveTopology(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"devices/system/cpu/online":         "0-7\n",
+		"devices/system/node/node0/cpulist": "0-3\n",
+		"devices/system/node/node1/cpulist": "4-7\n",
+		"devices/system/node/node2/cpulist": "\n",
+	}
+	for cpu := 0; cpu < 8; cpu++ {
+		// the sockets are 0-3 and 4-7, the siblings are n and n+2 in each socket
+		dir := fmt.Sprintf("devices/system/cpu/cpu%d/topology/", cpu)
+		files[dir+"physical_package_id"] = fmt.Sprintf("%d\n", cpu/4)
+		base := cpu/4*4 + cpu%2
+		files[dir+"thread_siblings_list"] = fmt.Sprintf("%d,%d\n", base, base+2)
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		numaNodes, sockets, smtSiblings string
+		expected                        string
+	}{
+		{"1", "", "", "4,5,6,7"},
+		{"", "0", "", "0,1,2,3"},
+		{"", "", "3", "1"},
+		{"", "", "0,5", "2,7"},
+		{"0-1", "1", "", "4,5,6,7"},
+		{"1", "", "4", "6"},
+		{"2", "", "", ""},
+		{"0", "1", "", ""},
+	}
+	for _, tt := range tests {
+		cpus, err := resolveTopology(root, tt.numaNodes, tt.sockets, tt.smtSiblings)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("%+v: expected error, got %v", tt, cpus)
+			}
+			continue
+		}
+		if err != nil || formatCpuList(cpus) != tt.expected {
+			t.Errorf("%+v: unexpected cpus: %v, %v", tt, cpus, err)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "cpu/docker/abc")
+	acctDir := filepath.Join(root, "cpuacct/docker/abc")
+	for name, content := range map[string]string{
+		filepath.Join(dir, "cpu.cfs_quota_us"):  "-1\n",
+		filepath.Join(dir, "cpu.cfs_period_us"): "100000\n",
+		filepath.Join(dir, "cpu.stat"):          "nr_periods 10\nnr_throttled 1\nthrottled_time 500\n",
+		filepath.Join(acctDir, "cpuacct.usage"): "1000\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cg := &exec.Cgroup{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{"version":1,"root":%q,"paths":{"cpu":"/docker/abc","cpuacct":"/docker/abc"}}`, root)), cg); err != nil {
+		t.Fatal(err)
+	}
+	recordDir := exec.RecordDir
+	exec.RecordDir = filepath.Join(t.TempDir(), "records")
+	defer func() { exec.RecordDir = recordDir }()
+
+	ctx := context.Background()
+	te := &throttleExecutor{}
+	if resp := te.start(ctx, "uid1", cg, 0.5, 0); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	if quota, _ := cg.ReadFile("cpu", "cpu.cfs_quota_us"); quota != "50000" {
+		t.Errorf("expected quota 50000, got %s", quota)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("nr_periods 20\nnr_throttled 8\nthrottled_time 2500\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp := te.stop(ctx, "uid1")
+	if !resp.Success {
+		t.Fatalf("stop failed, %s", resp.Err)
+	}
+	if result := resp.Result.(ThrottleResult); result.NrThrottled != 7 || result.ThrottledTime != 2000 {
+		t.Errorf("unexpected throttle result: %+v", result)
+	}
+	if quota, _ := cg.ReadFile("cpu", "cpu.cfs_quota_us"); quota != "-1" {
+		t.Errorf("expected quota restored to -1, got %s", quota)
+	}
+
+	// the throttle never raises a finite quota
+	if err := os.WriteFile(filepath.Join(dir, "cpu.cfs_quota_us"), []byte("150000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := te.start(ctx, "uid3", cg, 2, 0); resp.Success || resp.Code != spec.ParameterIllegal.Code {
+		t.Fatalf("expected the quota of 2 cores above 1.5 cores rejected, got %+v", resp)
+	}
+	if quota, _ := cg.ReadFile("cpu", "cpu.cfs_quota_us"); quota != "150000" {
+		t.Errorf("expected quota unchanged, got %s", quota)
+	}
+	if resp := te.stop(ctx, "uid3"); resp.Success {
+		t.Errorf("expected no record for the rejected throttle")
+	}
+	if resp := te.start(ctx, "uid3", cg, 1, 0); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	if quota, _ := cg.ReadFile("cpu", "cpu.cfs_quota_us"); quota != "100000" {
+		t.Errorf("expected quota 100000, got %s", quota)
+	}
+	if resp := te.stop(ctx, "uid3"); !resp.Success {
+		t.Fatalf("stop failed, %s", resp.Err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cpu.cfs_quota_us"), []byte("-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the quota is not below the kernel minimum
+	if resp := te.start(ctx, "uid2", cg, 0, 1); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	expected := runtime.NumCPU() * 1000
+	if expected < minQuota {
+		expected = minQuota
+	}
+	if quota, _ := cg.ReadFile("cpu", "cpu.cfs_quota_us"); quota != strconv.Itoa(expected) {
+		t.Errorf("expected quota of 1%% of %d cpus not less than %d, got %s", runtime.NumCPU(), minQuota, quota)
+	}
+
+	// the cgroup removed with the container is treated as restored
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if resp := te.stop(ctx, "uid2"); !resp.Success {
+		t.Errorf("expected the removed cgroup treated as restored, got %s", resp.Err)
+	}
+	if resp := te.stop(ctx, "uid2"); resp.Success {
+		t.Errorf("expected the record removed")
+	}
+}
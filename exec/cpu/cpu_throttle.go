@@ -0,0 +1,262 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const throttleAction = "cpu-throttle"
+
+// minQuota is the minimum cfs quota allowed by the kernel, unit is microsecond
+const minQuota = 1000
+
+type ThrottleActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewThrottleActionCommandSpec() spec.ExpActionCommandSpec {
+	return &ThrottleActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "pid",
+					Desc: "The pid of the target process, the cgroup of the process is throttled. The target pid of the nsexec channel is used if it is empty",
+				},
+			},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "cores",
+					Desc: "The number of cores allowed, such as 0.5 or 2",
+				},
+				&spec.ExpFlag{
+					Name: "percent",
+					Desc: "Percent of the current cpu quota allowed (1-100), the host cpu count is used if the quota is unlimited",
+				},
+			},
+			ActionExecutor: &throttleExecutor{},
+			ActionExample: `
+# Limit the cgroup of the process 1234 to half a core
+blade create cpu throttle --pid 1234 --cores 0.5
+
+# Lower the cpu quota of the container to 20% of its current value
+blade create cpu throttle --channel nsexec --ns_target 1234 --percent 20`,
+			ActionCategories: []string{category.SystemCpu},
+		},
+	}
+}
+
+func (*ThrottleActionCommandSpec) Name() string {
+	return "throttle"
+}
+
+func (*ThrottleActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*ThrottleActionCommandSpec) ShortDesc() string {
+	return "cpu throttle"
+}
+
+func (t *ThrottleActionCommandSpec) LongDesc() string {
+	if t.ActionLongDesc != "" {
+		return t.ActionLongDesc
+	}
+	return "Lower the cfs quota of the target process cgroup, and restore the original quota when the experiment is destroyed"
+}
+
+type throttleExecutor struct {
+	channel spec.Channel
+}
+
+func (te *throttleExecutor) Name() string {
+	return "throttle"
+}
+
+func (te *throttleExecutor) SetChannel(channel spec.Channel) {
+	te.channel = channel
+}
+
+// throttleRecord is the original cfs quota of the cgroup
+type throttleRecord struct {
+	Cgroup        *exec.Cgroup `json:"cgroup"`
+	File          string       `json:"file"`
+	Value         string       `json:"value"`
+	NrThrottled   uint64       `json:"nrThrottled"`
+	ThrottledTime uint64       `json:"throttledTime"`
+}
+
+// ThrottleResult is the throttling delta returned when the experiment is destroyed,
+// the throttled time is nanoseconds
+type ThrottleResult struct {
+	NrThrottled   uint64 `json:"nrThrottled"`
+	ThrottledTime uint64 `json:"throttledTime"`
+}
+
+func (te *throttleExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if te.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return te.stop(ctx, uid)
+	}
+
+	pidStr := model.ActionFlags["pid"]
+	if pidStr == "" {
+		pidStr = model.ActionFlags[channel.NSTargetFlagName]
+	}
+	if pidStr == "" {
+		log.Errorf(ctx, "pid is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "pid")
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		log.Errorf(ctx, "`%s`: pid is illegal, it must be a positive integer", pidStr)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "pid", pidStr, "it must be a positive integer")
+	}
+
+	coresStr := model.ActionFlags["cores"]
+	percentStr := model.ActionFlags["percent"]
+	var cores float64
+	var percent int
+	if coresStr != "" {
+		cores, err = strconv.ParseFloat(coresStr, 64)
+		if err != nil || cores <= 0 {
+			log.Errorf(ctx, "`%s`: cores is illegal, it must be a positive number", coresStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cores", coresStr, "it must be a positive number")
+		}
+	} else if percentStr != "" {
+		percent, err = strconv.Atoi(percentStr)
+		if err != nil || percent <= 0 || percent > 100 {
+			log.Errorf(ctx, "`%s`: percent is illegal, it must be a positive integer and not bigger than 100", percentStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "percent", percentStr, "it must be a positive integer and not bigger than 100")
+		}
+	} else {
+		log.Errorf(ctx, "cores or percent is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "cores|percent")
+	}
+
+	cg, err := exec.LoadCgroup(model.ActionFlags["cgroup-root"], pid)
+	if err != nil {
+		log.Errorf(ctx, "load cgroup of pid %d failed, %v", pid, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load cgroup", err)
+	}
+	return te.start(ctx, uid, cg, cores, percent)
+}
+
+func (te *throttleExecutor) start(ctx context.Context, uid string, cg *exec.Cgroup, cores float64, percent int) *spec.Response {
+	quota, period, err := cg.CPUMax()
+	if err != nil {
+		log.Errorf(ctx, "get cpu quota failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get cpu quota", err)
+	}
+	stat, err := cg.CPUStat()
+	if err != nil {
+		log.Errorf(ctx, "get cpu stat failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get cpu stat", err)
+	}
+
+	var newQuota int64
+	if cores > 0 {
+		newQuota = int64(cores * float64(period))
+	} else {
+		base := quota
+		if base <= 0 {
+			base = int64(runtime.NumCPU()) * int64(period)
+		}
+		newQuota = base * int64(percent) / 100
+	}
+	if newQuota < minQuota {
+		newQuota = minQuota
+	}
+	// the throttle must not raise a finite quota
+	if quota > 0 && newQuota >= quota {
+		flag, value := "percent", strconv.Itoa(percent)
+		if cores > 0 {
+			flag, value = "cores", strconv.FormatFloat(cores, 'f', -1, 64)
+		}
+		log.Errorf(ctx, "`%s`: %s is illegal, the quota %d is not lower than the current quota %d", value, flag, newQuota, quota)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, flag, value,
+			fmt.Sprintf("it must be lower than the current quota of %g cores", float64(quota)/float64(period)))
+	}
+
+	record := &throttleRecord{Cgroup: cg, NrThrottled: stat.NrThrottled, ThrottledTime: stat.ThrottledTime}
+	var value string
+	if cg.Version() == exec.CgroupV2 {
+		record.File = "cpu.max"
+		value = fmt.Sprintf("%d %d", newQuota, period)
+	} else {
+		record.File = "cpu.cfs_quota_us"
+		value = strconv.FormatInt(newQuota, 10)
+	}
+	if record.Value, err = cg.ReadFile("cpu", record.File); err != nil {
+		log.Errorf(ctx, "read %s failed, %v", record.File, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "read "+record.File, err)
+	}
+	if err := exec.SaveRecord(throttleAction, uid, record); err != nil {
+		log.Errorf(ctx, "save the original cpu quota failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the original cpu quota", err)
+	}
+	log.Infof(ctx, "throttle cpu, %s: %s -> %s", record.File, record.Value, value)
+	if err := cg.WriteFile("cpu", record.File, value); err != nil {
+		exec.RemoveRecord(throttleAction, uid)
+		log.Errorf(ctx, "write %s failed, %v", record.File, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "write "+record.File, err)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func (te *throttleExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record throttleRecord
+	if err := exec.LoadRecord(throttleAction, uid, &record); err != nil {
+		log.Errorf(ctx, "load the original cpu quota failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the original cpu quota", err)
+	}
+	result := ThrottleResult{}
+	err := record.Cgroup.WriteFile("cpu", record.File, record.Value)
+	switch {
+	case os.IsNotExist(err):
+		// the cgroup is removed with the container, there is nothing to restore
+		log.Infof(ctx, "the cgroup is removed, skip restoring %s", record.File)
+	case err != nil:
+		log.Errorf(ctx, "restore %s to %s failed, %v", record.File, record.Value, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "restore "+record.File, err)
+	default:
+		log.Infof(ctx, "restore cpu quota, %s: %s", record.File, record.Value)
+		if stat, err := record.Cgroup.CPUStat(); err != nil {
+			log.Warnf(ctx, "get cpu stat failed, %v", err)
+		} else if stat.NrThrottled >= record.NrThrottled && stat.ThrottledTime >= record.ThrottledTime {
+			result.NrThrottled = stat.NrThrottled - record.NrThrottled
+			result.ThrottledTime = stat.ThrottledTime - record.ThrottledTime
+		}
+	}
+	if err := exec.RemoveRecord(throttleAction, uid); err != nil {
+		log.Warnf(ctx, "remove the original cpu quota record failed, %v", err)
+	}
+	return spec.ReturnSuccess(result)
+}
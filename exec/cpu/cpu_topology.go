@@ -0,0 +1,178 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+)
+
+// topologySelectors are the flags resolved from the cpu topology into the cpu-list
+var topologySelectors = []string{"numa-node", "socket", "smt-siblings"}
+
+// resolveTopology returns the online cores selected by the numa nodes, the sockets
+// and the smt siblings, the cores selected by every non-empty selector are returned
+func resolveTopology(sysfsRoot, numaNodes, sockets, smtSiblings string) ([]int, error) {
+	online, err := onlineCpus(sysfsRoot)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[int]int, len(online))
+	selectors := 0
+	for _, s := range []struct {
+		name, value string
+		resolve     func(string, string) ([]int, error)
+	}{
+		{"numa-node", numaNodes, nodeCpus},
+		{"socket", sockets, socketCpus},
+		{"smt-siblings", smtSiblings, siblingCpus},
+	} {
+		if s.value == "" {
+			continue
+		}
+		selectors++
+		ids, err := util.ParseIntegerListToStringSlice(s.name, s.value)
+		if err != nil {
+			return nil, err
+		}
+		cpus := make(map[int]bool)
+		for _, id := range ids {
+			c, err := s.resolve(sysfsRoot, id)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", s.name, id, err)
+			}
+			for _, cpu := range c {
+				cpus[cpu] = true
+			}
+		}
+		for cpu := range cpus {
+			selected[cpu]++
+		}
+	}
+	var cpus []int
+	for _, cpu := range online {
+		if selected[cpu] == selectors {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no online core is selected")
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// nodeCpus returns the cores of the numa node from devices/system/node/node$N/cpulist
+func nodeCpus(sysfsRoot, node string) ([]int, error) {
+	if sysfsRoot == "" {
+		sysfsRoot = "/sys"
+	}
+	return readCpuList(filepath.Join(sysfsRoot, "devices/system/node", "node"+node, "cpulist"))
+}
+
+// socketCpus returns the cores whose topology/physical_package_id is the socket
+func socketCpus(sysfsRoot, socket string) ([]int, error) {
+	online, err := onlineCpus(sysfsRoot)
+	if err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for _, cpu := range online {
+		id, err := readTopology(sysfsRoot, cpu, "physical_package_id")
+		if err != nil {
+			return nil, err
+		}
+		if id == socket {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no online core is found")
+	}
+	return cpus, nil
+}
+
+// siblingCpus returns the hyperthread siblings of the core from topology/thread_siblings_list,
+// the core itself is excluded
+func siblingCpus(sysfsRoot, core string) ([]int, error) {
+	c, err := strconv.Atoi(core)
+	if err != nil {
+		return nil, err
+	}
+	siblings, err := readCpuList(filepath.Join(cpuSysfsPath(sysfsRoot), "cpu"+core, "topology", "thread_siblings_list"))
+	if err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for _, cpu := range siblings {
+		if cpu != c {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("the core has no smt sibling")
+	}
+	return cpus, nil
+}
+
+func readTopology(sysfsRoot string, cpu int, name string) (string, error) {
+	bytes, err := os.ReadFile(filepath.Join(cpuSysfsPath(sysfsRoot), fmt.Sprintf("cpu%d", cpu), "topology", name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// readCpuList reads the cpu list file, such as 0-3,8-11
+func readCpuList(path string) ([]int, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	value := strings.TrimSpace(string(bytes))
+	if value == "" {
+		return nil, nil
+	}
+	cores, err := util.ParseIntegerListToStringSlice(filepath.Base(path), value)
+	if err != nil {
+		return nil, err
+	}
+	cpus := make([]int, 0, len(cores))
+	for _, core := range cores {
+		c, err := strconv.Atoi(core)
+		if err != nil {
+			return nil, err
+		}
+		cpus = append(cpus, c)
+	}
+	return cpus, nil
+}
+
+// formatCpuList formats the cores as the cpu-list flag value
+func formatCpuList(cpus []int) string {
+	cores := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		cores[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(cores, ",")
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cpu
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const (
+	WorkloadSpin    = "spin"
+	WorkloadInteger = "integer"
+	WorkloadFloat   = "float"
+	WorkloadSimd    = "simd"
+	WorkloadCache   = "cache"
+	WorkloadBranch  = "branch"
+)
+
+var workloads = map[string]func() func(){
+	WorkloadSpin:    func() func() { return func() {} },
+	WorkloadInteger: newIntegerWorkload,
+	WorkloadFloat:   newFloatWorkload,
+	WorkloadSimd:    newSimdWorkload,
+	WorkloadCache:   newCacheWorkload,
+	WorkloadBranch:  newBranchWorkload,
+}
+
+// newWorkload returns the burn kernel of the workload, each call of the kernel
+// does a small batch of work, so that the busy time of the quota is still honored.
+func newWorkload(kind string) (func(), bool) {
+	if kind == "" {
+		kind = WorkloadSpin
+	}
+	f, ok := workloads[kind]
+	if !ok {
+		return nil, false
+	}
+	return f(), true
+}
+
+// newIntegerWorkload stresses the integer ALUs with xorshift and multiplications
+func newIntegerWorkload() func() {
+	x := uint64(88172645463325252)
+	return func() {
+		for i := 0; i < 1024; i++ {
+			x ^= x << 13
+			x ^= x >> 7
+			x ^= x << 17
+			x *= 2685821657736338717
+		}
+	}
+}
+
+// newFloatWorkload stresses the floating point units
+func newFloatWorkload() func() {
+	x := 1.0
+	return func() {
+		for i := 0; i < 256; i++ {
+			x = math.Sqrt(x*1.000001+float64(i)) + math.Sin(x)*math.Cos(x)
+		}
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			x = 1.0
+		}
+	}
+}
+
+// newSimdWorkload stresses the vector units with the independent fused multiply-adds of
+// fmaVector, which uses avx2 on amd64 and neon on arm64, the scalar math.FMA is the fallback
+// of the other platforms and the amd64 cpus without avx2
+func newSimdWorkload() func() {
+	acc := &[simdLanes]float64{}
+	return func() {
+		fmaVector(acc, 1e-3, 1e-9, 4096)
+	}
+}
+
+const cacheThrashSize = 64 * 1024 * 1024
+
+var (
+	cacheThrashOnce  sync.Once
+	cacheThrashChain []uint32
+)
+
+// newCacheWorkload walks a random pointer chain over a buffer larger than the
+// last level cache, every access misses the cache and defeats the prefetcher.
+// The chain is shared by all burn goroutines of the process.
+func newCacheWorkload() func() {
+	cacheThrashOnce.Do(func() {
+		// Sattolo's algorithm generates a single cycle over all the elements
+		n := cacheThrashSize / 4
+		cacheThrashChain = make([]uint32, n)
+		for i := range cacheThrashChain {
+			cacheThrashChain[i] = uint32(i)
+		}
+		for i := n - 1; i > 0; i-- {
+			j := rand.Intn(i)
+			cacheThrashChain[i], cacheThrashChain[j] = cacheThrashChain[j], cacheThrashChain[i]
+		}
+	})
+	next := uint32(rand.Intn(len(cacheThrashChain)))
+	return func() {
+		for i := 0; i < 256; i++ {
+			next = cacheThrashChain[next]
+		}
+	}
+}
+
+// newBranchWorkload runs data dependent branches on random values which the
+// branch predictor cannot learn
+func newBranchWorkload() func() {
+	x := rand.Uint32() | 1
+	var count uint64
+	return func() {
+		for i := 0; i < 1024; i++ {
+			x ^= x << 13
+			x ^= x >> 17
+			x ^= x << 5
+			if x&1 == 0 {
+				count += uint64(x >> 3)
+			} else if x&2 == 0 {
+				count ^= uint64(x)
+			} else {
+				count--
+			}
+		}
+	}
+}
@@ -21,7 +21,10 @@ import (
 	"fmt"
 	"github.com/chaosblade-io/chaosblade-spec-go/channel"
 	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"os"
+	osexec "os/exec"
 	"strings"
+	"syscall"
 )
 
 // todo
@@ -55,6 +58,16 @@ func Destroy(ctx context.Context, c spec.Channel, action string) *spec.Response
 	return cl.Run(ctx, "kill", fmt.Sprintf(`-9 %s`, strings.Join(pids, " ")))
 }
 
+// StartPinned runs chaos_os with the args in a new process pinned to the core by taskset
+func StartPinned(ctx context.Context, core, args string) *spec.Response {
+	command := osexec.CommandContext(ctx, "taskset", append([]string{"-c", core, os.Args[0]}, strings.Split(args, " ")...)...)
+	command.SysProcAttr = &syscall.SysProcAttr{}
+	if err := command.Start(); err != nil {
+		return spec.ReturnFail(spec.OsCmdExecFailed, fmt.Sprintf("taskset exec failed, %v", err))
+	}
+	return nil
+}
+
 func CheckFilepathExists(ctx context.Context, cl spec.Channel, filepath string) bool {
 	response := cl.Run(ctx, fmt.Sprintf("[ -e %s ] && echo true || echo false", filepath), "")
 	if response.Success && strings.Contains(response.Result.(string), "true") {
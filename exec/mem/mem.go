@@ -21,14 +21,14 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"path"
 	"strconv"
+	"strings"
 	"time"
+	"unsafe"
 
 	"github.com/chaosblade-io/chaosblade-spec-go/channel"
 	"github.com/chaosblade-io/chaosblade-spec-go/log"
 	"github.com/chaosblade-io/chaosblade-spec-go/spec"
-	"github.com/chaosblade-io/chaosblade-spec-go/util"
 
 	"github.com/chaosblade-io/chaosblade-exec-os/exec"
 	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
@@ -47,7 +47,38 @@ func NewMemCommandModelSpec() spec.ExpModelCommandSpec {
 				&MemLoadActionCommand{
 					spec.BaseExpActionCommandSpec{
 						ActionMatchers: []spec.ExpFlagSpec{},
-						ActionFlags:    []spec.ExpFlagSpec{},
+						ActionFlags: []spec.ExpFlagSpec{
+							&spec.ExpFlag{
+								Name:     "size",
+								Desc:     "size of Memory to burn and hold regardless of the available memory, unit is MB, G or GB suffix is supported, such as 512 or 2G. It cannot be used with mem-percent or reserve",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "mode",
+								Desc:     "burn memory mode, cache, ram, hugepage or thp. cache fills a dedicated tmpfs capped to the expected size, which is unmounted on destroy. hugepage allocates from nr_hugepages by MAP_HUGETLB, thp allocates anonymous memory advised by MADV_HUGEPAGE",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:   "lock",
+								Desc:   "Touch every page and mlock the burned memory, so that it is resident and never swapped out, the RLIMIT_MEMLOCK is respected, only support for ram mode",
+								NoArgs: true,
+							},
+							&spec.ExpFlag{
+								Name:     "touch-interval",
+								Desc:     "interval(s) to touch every page of the burned memory to keep the pages hot, only support for ram mode",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:     "numa-node",
+								Desc:     "The numa node which the burned memory is bound to by mbind, the percent and the reserve are relative to the memory of the node",
+								Required: false,
+							},
+							&spec.ExpFlag{
+								Name:   "join-cgroup",
+								Desc:   "Move the burn process into the cgroup of the target pid of the nsexec channel, so that the burn is accounted against the container limits",
+								NoArgs: true,
+							},
+						},
 						ActionExecutor: &memExecutor{},
 						ActionExample: `
 # The execution memory footprint is 50%
@@ -65,6 +96,27 @@ blade create mem load --mode ram --mem-percent 50 --avoid-being-killed
 # The execution memory footprint is 50% for 200 seconds
 blade create mem load --mode ram --mem-percent 50 --timeout 200
 
+# The execution memory footprint is 50% of the container limit, the burn process runs inside the container cgroup
+blade create mem load --mode ram --mem-percent 50 --channel nsexec --ns_target 1234 --join-cgroup
+
+# Burn exactly 2G memory at 100M/s and hold it, whatever the other processes use
+blade create mem load --mode ram --size 2G --rate 100
+
+# Burn 1G resident memory, the memory is locked and never swapped out
+blade create mem load --mode ram --size 1G --lock
+
+# Burn 50% memory and touch all pages every 30 seconds to keep them hot
+blade create mem load --mode ram --mem-percent 50 --touch-interval 30
+
+# Burn 50% of the explicit huge pages
+blade create mem load --mode hugepage --mem-percent 50
+
+# Burn 2G memory backed by transparent huge pages
+blade create mem load --mode thp --size 2G
+
+# Burn 80% memory of the numa node 1, the other nodes are not touched
+blade create mem load --mode ram --mem-percent 80 --numa-node 1
+
 # 200M memory is reserved
 blade create mem load --mode ram --reserve 200 --rate 100`,
 						ActionPrograms:    []string{BurnMemBin},
@@ -72,6 +124,12 @@ blade create mem load --mode ram --reserve 200 --rate 100`,
 						ActionProcessHang: true,
 					},
 				},
+				NewHugepageExhaustActionCommandSpec(),
+				NewMemLeakActionCommandSpec(),
+				NewMemBandwidthActionCommandSpec(),
+				NewMemLimitActionCommandSpec(),
+				NewMemSwapActionCommandSpec(),
+				NewCacheEvictActionCommandSpec(),
 			},
 			ExpFlags: []spec.ExpFlagSpec{
 				&spec.ExpFlag{
@@ -89,11 +147,6 @@ blade create mem load --mode ram --reserve 200 --rate 100`,
 					Desc:     "burn memory rate, unit is M/S, only support for ram mode.",
 					Required: false,
 				},
-				&spec.ExpFlag{
-					Name:     "mode",
-					Desc:     "burn memory mode, cache or ram.",
-					Required: false,
-				},
 				&spec.ExpFlag{
 					Name:   "include-buffer-cache",
 					Desc:   "Ram mode mem-percent is include buffer/cache",
@@ -159,8 +212,8 @@ func (*MemLoadActionCommand) Matchers() []spec.ExpFlagSpec {
 	return []spec.ExpFlagSpec{}
 }
 
-func (*MemLoadActionCommand) Flags() []spec.ExpFlagSpec {
-	return []spec.ExpFlagSpec{}
+func (m *MemLoadActionCommand) Flags() []spec.ExpFlagSpec {
+	return m.ActionFlags
 }
 
 type memExecutor struct {
@@ -183,7 +236,7 @@ const (
 )
 
 func (ce *memExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
-	commands := []string{"dd", "mount", "umount"}
+	commands := []string{"mount", "umount"}
 	if response, ok := ce.channel.IsAllCommandsAvailable(ctx, commands); !ok {
 		return response
 	}
@@ -193,19 +246,33 @@ func (ce *memExecutor) Exec(uid string, ctx context.Context, model *spec.ExpMode
 		return spec.ResponseFailWithFlags(spec.ChannelNil)
 	}
 	if _, ok := spec.IsDestroy(ctx); ok {
-		return ce.stop(ctx, model.ActionFlags["mode"])
+		return ce.stop(ctx, uid)
 	}
-	var memPercent, memReserve, memRate int
+	var memPercent, memReserve, memRate, memSize int
 
 	memPercentStr := model.ActionFlags["mem-percent"]
 	memReserveStr := model.ActionFlags["reserve"]
+	memSizeStr := model.ActionFlags["size"]
 	memRateStr := model.ActionFlags["rate"]
 	burnMemModeStr := model.ActionFlags["mode"]
 	includeBufferCache := model.ActionFlags["include-buffer-cache"] == "true"
 	avoidBeingKilled := model.ActionFlags["avoid-being-killed"] == "true"
+	lock := model.ActionFlags["lock"] == "true"
+	touchIntervalStr := model.ActionFlags["touch-interval"]
+	numaNodeStr := model.ActionFlags["numa-node"]
 
 	var err error
-	if memPercentStr != "" {
+	if memSizeStr != "" {
+		if memPercentStr != "" || memReserveStr != "" {
+			log.Errorf(ctx, "`%s`: size cannot be used with mem-percent or reserve", memSizeStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "size", memSizeStr, "it cannot be used with mem-percent or reserve")
+		}
+		memSize, err = parseMemSize(memSizeStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: size is illegal, %v", memSizeStr, err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "size", memSizeStr, err)
+		}
+	} else if memPercentStr != "" {
 		var err error
 		memPercent, err = strconv.Atoi(memPercentStr)
 		if err != nil {
@@ -231,19 +298,65 @@ func (ce *memExecutor) Exec(uid string, ctx context.Context, model *spec.ExpMode
 			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "rate", memRateStr, "it must be a positive integer")
 		}
 	}
+	switch burnMemModeStr {
+	case "", "ram", "cache", "hugepage", "thp":
+	default:
+		log.Errorf(ctx, "`%s`: mode is illegal, it must be cache, ram, hugepage or thp", burnMemModeStr)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "mode", burnMemModeStr, "it must be cache, ram, hugepage or thp")
+	}
+	var touchInterval time.Duration
+	if touchIntervalStr != "" {
+		interval, err := strconv.Atoi(touchIntervalStr)
+		if err != nil || interval <= 0 {
+			log.Errorf(ctx, "`%s`: touch-interval must be a positive integer", touchIntervalStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "touch-interval", touchIntervalStr, "it must be a positive integer")
+		}
+		touchInterval = time.Duration(interval) * time.Second
+	}
+	if (lock || touchInterval > 0) && burnMemModeStr == "cache" {
+		log.Errorf(ctx, "lock and touch-interval only support for ram mode")
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "mode", burnMemModeStr, "lock and touch-interval only support for ram mode")
+	}
 	ctx = context.WithValue(ctx, "cgroup-root", model.ActionFlags["cgroup-root"])
-	ce.start(ctx, memPercent, memReserve, memRate, burnMemModeStr, includeBufferCache, avoidBeingKilled, ce.channel)
-	return spec.Success()
+	if numaNodeStr != "" {
+		node, err := strconv.Atoi(numaNodeStr)
+		if err != nil || node < 0 {
+			log.Errorf(ctx, "`%s`: numa-node must be a non-negative integer", numaNodeStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "numa-node", numaNodeStr, "it must be a non-negative integer")
+		}
+		if err := checkNumaNode(node); err != nil {
+			log.Errorf(ctx, "`%s`: numa-node is illegal, %v", numaNodeStr, err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "numa-node", numaNodeStr, err)
+		}
+		ctx = context.WithValue(ctx, "numa-node", node)
+	}
+	if model.ActionFlags["join-cgroup"] == spec.True {
+		if resp := exec.JoinTargetCgroup(ctx, model, "memory"); resp != nil {
+			return resp
+		}
+	}
+	return ce.start(ctx, uid, memPercent, memReserve, memRate, memSize, burnMemModeStr, includeBufferCache, avoidBeingKilled, lock, touchInterval, ce.channel)
 }
 
 // 128K
 type Block [32 * 1024]int32
 
+const blockSize = int(unsafe.Sizeof(Block{}))
+
 const PageCounterMax uint64 = 9223372036854770000
 
 func calculateMemSize(ctx context.Context, burnMemMode string, percent, reserve int, includeBufferCache bool) (int64, int64, error) {
 
-	total, available, err := getAvailableAndTotal(ctx, burnMemMode, includeBufferCache)
+	var total, available int64
+	var err error
+	if node := numaNode(ctx); node >= 0 {
+		total, available, err = getNodeAvailableAndTotal(node, burnMemMode, includeBufferCache)
+	} else if burnMemMode == "hugepage" {
+		// the percent and the reserve are relative to the huge page pool
+		total, available, err = getHugepageAvailableAndTotal()
+	} else {
+		total, available, err = getAvailableAndTotal(ctx, burnMemMode, includeBufferCache)
+	}
 	if err != nil {
 		return 0, 0, err
 	}
@@ -262,37 +375,38 @@ func calculateMemSize(ctx context.Context, burnMemMode string, percent, reserve
 	return total / 1024 / 1024, expectSize, nil
 }
 
-var dirName = "burnmem_tmpfs"
-
-var fileName = "file"
-
-var fileCount = 1
-
-func burnMemWithCache(ctx context.Context, memPercent, memReserve, memRate int, burnMemMode string, includeBufferCache bool, cl spec.Channel) {
-	filePath := path.Join(path.Join(util.GetProgramPath(), dirName), fileName)
-	tick := time.Tick(time.Second)
-	for range tick {
-		_, expectMem, err := calculateMemSize(ctx, burnMemMode, memPercent, memReserve, includeBufferCache)
-		if err != nil {
-			log.Fatalf(ctx, "calculate memsize err, %v", err)
-		}
-		fillMem := expectMem
-		if expectMem > 0 {
-			if expectMem > int64(memRate) {
-				fillMem = int64(memRate)
-			}
-			nFilePath := fmt.Sprintf("%s%d", filePath, fileCount)
-			response := cl.Run(ctx, "dd", fmt.Sprintf("if=/dev/zero of=%s bs=1M count=%d", nFilePath, fillMem))
-			if !response.Success {
-				log.Fatalf(ctx, "burn mem with cache err, %v", err)
-			}
-			fileCount++
+// parseMemSize parses the size flag, such as 512, 512M or 2G, and returns the size in MB
+func parseMemSize(size string) (int, error) {
+	value, unit := strings.ToUpper(strings.TrimSpace(size)), 1
+	for _, suffix := range []struct {
+		name string
+		unit int
+	}{{"GB", 1024}, {"G", 1024}, {"MB", 1}, {"M", 1}} {
+		if strings.HasSuffix(value, suffix.name) {
+			value, unit = strings.TrimSuffix(value, suffix.name), suffix.unit
+			break
 		}
 	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("it must be a positive integer with an optional M, MB, G or GB suffix")
+	}
+	return n * unit, nil
+}
+
+// expectMemSize returns the memory to burn in MB. For the size mode it is the rest of the
+// size, otherwise it is calculated from the available memory
+func expectMemSize(ctx context.Context, burnMemMode string, percent, reserve, size int, filled int64, includeBufferCache bool) (int64, error) {
+	if size > 0 {
+		return int64(size) - filled, nil
+	}
+	_, expectMem, err := calculateMemSize(ctx, burnMemMode, percent, reserve, includeBufferCache)
+	return expectMem, err
 }
 
 // start burn mem
-func (ce *memExecutor) start(ctx context.Context, memPercent, memReserve, memRate int, burnMemMode string, includeBufferCache bool, avoidBeingKilled bool, cl spec.Channel) {
+func (ce *memExecutor) start(ctx context.Context, uid string, memPercent, memReserve, memRate, memSize int, burnMemMode string, includeBufferCache bool,
+	avoidBeingKilled bool, lock bool, touchInterval time.Duration, cl spec.Channel) *spec.Response {
 	// adjust process oom_score_adj to avoid being killed
 	if avoidBeingKilled {
 		// not works for the channel.NSExecChannel
@@ -310,19 +424,39 @@ func (ce *memExecutor) start(ctx context.Context, memPercent, memReserve, memRat
 		}
 	}
 
+	if memRate <= 0 {
+		memRate = 100
+	}
 	if burnMemMode == "cache" {
-		burnMemWithCache(ctx, memPercent, memReserve, memRate, burnMemMode, includeBufferCache, cl)
-		return
+		return burnMemWithCache(ctx, uid, memPercent, memReserve, memRate, memSize, burnMemMode, includeBufferCache, cl)
 	}
 	tick := time.Tick(time.Second)
 	var cache = make(map[int][]Block, 1)
 	var count = 1
 	cache[count] = make([]Block, 0)
-	if memRate <= 0 {
-		memRate = 100
+	var filled int64
+	// a new buffer is allocated for each fill instead of growing the buffer, if the size is
+	// held exactly or the pages are locked or touched, so that no garbage copy is left. The numa
+	// binding also requires the chunks, the go heap cannot be bound to a node.
+	node := numaNode(ctx)
+	chunked := memSize > 0 || lock || touchInterval > 0 || burnMemMode == "hugepage" || burnMemMode == "thp" || node >= 0
+	var locker *memLocker
+	if lock {
+		locker = newMemLocker(ctx)
 	}
-	for range tick {
-		_, expectMem, err := calculateMemSize(ctx, burnMemMode, memPercent, memReserve, includeBufferCache)
+	lastTouch, lastReport := time.Now(), time.Now()
+	for now := range tick {
+		if touchInterval > 0 && now.Sub(lastTouch) >= touchInterval {
+			for _, buf := range cache {
+				touchBlocks(buf)
+			}
+			lastTouch = now
+		}
+		if (lock || touchInterval > 0) && now.Sub(lastReport) >= memReportInterval {
+			reportResidentMemory(ctx, filled)
+			lastReport = now
+		}
+		expectMem, err := expectMemSize(ctx, burnMemMode, memPercent, memReserve, memSize, filled, includeBufferCache)
 		if err != nil {
 			log.Fatalf(ctx, "calculate memsize err, %v", err.Error())
 		}
@@ -330,13 +464,33 @@ func (ce *memExecutor) start(ctx context.Context, memPercent, memReserve, memRat
 		if expectMem > 0 {
 			if expectMem > int64(memRate) {
 				fillMem = int64(memRate)
-			} else {
+			} else if memSize == 0 {
 				fillMem = expectMem / 10
 				if fillMem == 0 {
 					continue
 				}
 			}
+			if burnMemMode == "hugepage" {
+				if fillMem, err = hugepageFillSize(fillMem, expectMem); err != nil || fillMem == 0 {
+					continue
+				}
+			}
 			fillSize := int(8 * fillMem)
+			if chunked {
+				blocks, err := allocBlocks(burnMemMode, fillSize, node)
+				if err != nil {
+					log.Warnf(ctx, "allocate %dMB memory by %s mode failed, %v", fillMem, burnMemMode, err)
+					continue
+				}
+				count += 1
+				cache[count] = touchBlocks(blocks)
+				if locker != nil {
+					locker.lock(ctx, cache[count])
+				}
+				filled += fillMem
+				log.Debugf(ctx, "count: %d, filled: %d, size: %d", count, filled, memSize)
+				continue
+			}
 			buf := cache[count]
 			if cap(buf)-len(buf) < fillSize &&
 				int(math.Floor(float64(cap(buf))*1.25)) >= int(8*expectMem) {
@@ -349,10 +503,26 @@ func (ce *memExecutor) start(ctx context.Context, memPercent, memReserve, memRat
 			cache[count] = append(buf, make([]Block, fillSize)...)
 		}
 	}
+	return spec.Success()
+}
+
+// touchBlocks writes every page of the blocks, so that they are resident
+func touchBlocks(blocks []Block) []Block {
+	for i := range blocks {
+		for j := 0; j < len(blocks[i]); j += 1024 {
+			blocks[i][j] = 1
+		}
+	}
+	return blocks
 }
 
-// stop burn mem
-func (ce *memExecutor) stop(ctx context.Context, burnMemMode string) *spec.Response {
+// stop burn mem, the tmpfs of the cache mode is unmounted after the burn process is killed
+func (ce *memExecutor) stop(ctx context.Context, uid string) *spec.Response {
 	ctx = context.WithValue(ctx, "bin", BurnMemBin)
-	return exec.Destroy(ctx, ce.channel, "mem load")
+	response := exec.Destroy(ctx, ce.channel, "mem load")
+	if err := umountCacheTmpfs(ctx, ce.channel, uid); err != nil {
+		log.Errorf(ctx, "clean the tmpfs of the cache mode failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "umount tmpfs", err)
+	}
+	return response
 }
@@ -0,0 +1,340 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const (
+	KernelCopy  = "copy"
+	KernelRead  = "read"
+	KernelWrite = "write"
+)
+
+const (
+	// bandwidthChunk is the bytes streamed by a kernel between two rate checks
+	bandwidthChunk = 1 << 20
+	// defaultBufferSize is used if the size of the last level cache is unknown
+	defaultBufferSize = 256 << 20
+)
+
+// cacheSysfsPath is the cache topology of cpu0
+var cacheSysfsPath = "/sys/devices/system/cpu/cpu0/cache"
+
+type MemBandwidthActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewMemBandwidthActionCommandSpec() spec.ExpActionCommandSpec {
+	return &MemBandwidthActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "bandwidth",
+					Desc: "The target memory bandwidth in GB/s of all threads, the bytes read and written are both counted, 0 or not set means unlimited",
+				},
+				&spec.ExpFlag{
+					Name: "kernel",
+					Desc: "The streaming kernel, copy, read or write, default value is copy",
+				},
+				&spec.ExpFlag{
+					Name: "buffer-size",
+					Desc: "The size of each buffer, unit is MB, G or GB suffix is supported. The default value is twice the size of the last level cache",
+				},
+				&spec.ExpFlag{
+					Name: "threads",
+					Desc: "The number of streaming threads, default value is 1. It is ignored if cpu-list is specified, a thread is pinned to each core",
+				},
+				&spec.ExpFlag{
+					Name: "cpu-list",
+					Desc: "CPUs in which to stream (0-3 or 1,3), a process pinned by taskset is created for each core",
+				},
+			},
+			ActionExecutor: &memBandwidthExecutor{},
+			ActionExample: `
+# Stream memcpy at the full speed of a thread
+blade create mem bandwidth
+
+# Consume 10GB/s memory bandwidth by the cores 0-3
+blade create mem bandwidth --cpu-list 0-3 --bandwidth 10
+
+# Read 1G buffers by 2 threads at 4GB/s
+blade create mem bandwidth --kernel read --buffer-size 1G --threads 2 --bandwidth 4`,
+			ActionCategories:  []string{category.SystemMem},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*MemBandwidthActionCommandSpec) Name() string {
+	return "bandwidth"
+}
+
+func (*MemBandwidthActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*MemBandwidthActionCommandSpec) ShortDesc() string {
+	return "mem bandwidth"
+}
+
+func (b *MemBandwidthActionCommandSpec) LongDesc() string {
+	if b.ActionLongDesc != "" {
+		return b.ActionLongDesc
+	}
+	return "Run streaming copy, read or write kernels over buffers larger than the last level cache at a target bandwidth, " +
+		"to emulate the memory bus contention and the cache thrash"
+}
+
+type memBandwidthExecutor struct {
+	channel spec.Channel
+}
+
+func (be *memBandwidthExecutor) Name() string {
+	return "bandwidth"
+}
+
+func (be *memBandwidthExecutor) SetChannel(channel spec.Channel) {
+	be.channel = channel
+}
+
+func (be *memBandwidthExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if be.channel == nil {
+		log.Errorf(ctx, spec.ChannelNil.Msg)
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return exec.Destroy(ctx, be.channel, "mem bandwidth")
+	}
+
+	var bandwidth float64
+	if bandwidthStr := model.ActionFlags["bandwidth"]; bandwidthStr != "" {
+		var err error
+		bandwidth, err = strconv.ParseFloat(bandwidthStr, 64)
+		if err != nil || bandwidth < 0 {
+			log.Errorf(ctx, "`%s`: bandwidth must be a non-negative number", bandwidthStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "bandwidth", bandwidthStr, "it must be a non-negative number")
+		}
+	}
+	kernel := model.ActionFlags["kernel"]
+	if kernel == "" {
+		kernel = KernelCopy
+	}
+	if kernel != KernelCopy && kernel != KernelRead && kernel != KernelWrite {
+		log.Errorf(ctx, "`%s`: kernel must be copy, read or write", kernel)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "kernel", kernel, "it must be copy, read or write")
+	}
+	var bufferSize int
+	if bufferSizeStr := model.ActionFlags["buffer-size"]; bufferSizeStr != "" {
+		size, err := parseMemSize(bufferSizeStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: buffer-size is illegal, %v", bufferSizeStr, err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "buffer-size", bufferSizeStr, err)
+		}
+		bufferSize = size << 20
+	} else if llc, err := lastLevelCacheSize(cacheSysfsPath); err != nil || 2*llc < bandwidthChunk {
+		// a vm may only expose the small l1 cache
+		log.Warnf(ctx, "the last level cache size %d is unknown or too small, %v, the buffer size is %d", llc, err, defaultBufferSize)
+		bufferSize = defaultBufferSize
+	} else {
+		bufferSize = 2 * llc
+	}
+	bufferSize = alignBufferSize(bufferSize)
+	threads := 1
+	if threadsStr := model.ActionFlags["threads"]; threadsStr != "" {
+		var err error
+		threads, err = strconv.Atoi(threadsStr)
+		if err != nil || threads <= 0 {
+			log.Errorf(ctx, "`%s`: threads must be a positive integer", threadsStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "threads", threadsStr, "it must be a positive integer")
+		}
+	}
+
+	if cpuListStr := model.ActionFlags["cpu-list"]; cpuListStr != "" {
+		if !be.channel.IsCommandAvailable(ctx, "taskset") {
+			return spec.ResponseFailWithFlags(spec.CommandTasksetNotFound)
+		}
+		cores, err := util.ParseIntegerListToStringSlice("cpu-list", cpuListStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: cpu-list is illegal, %s", cpuListStr, err.Error())
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cpu-list", cpuListStr, err.Error())
+		}
+		return be.startPinned(ctx, util.RemoveDuplicates(cores), bandwidth, kernel, bufferSize)
+	}
+	return be.start(ctx, threads, bandwidth, kernel, bufferSize)
+}
+
+// startPinned creates a process pinned to each core by taskset, the same as cpu fullload,
+// the bandwidth is shared by the cores evenly
+func (be *memBandwidthExecutor) startPinned(ctx context.Context, cores []string, bandwidth float64, kernel string, bufferSize int) *spec.Response {
+	for _, core := range cores {
+		args := fmt.Sprintf(`create mem bandwidth --bandwidth %g --kernel %s --buffer-size %d --threads 1 --uid %s`,
+			bandwidth/float64(len(cores)), kernel, bufferSize>>20, ctx.Value(spec.Uid))
+		if resp := exec.StartPinned(ctx, core, args); resp != nil {
+			return resp
+		}
+	}
+	return spec.ReturnSuccess(ctx.Value(spec.Uid))
+}
+
+// start runs the streaming threads and reports the achieved bandwidth
+func (be *memBandwidthExecutor) start(ctx context.Context, threads int, bandwidth float64, kernel string, bufferSize int) *spec.Response {
+	var streamed uint64
+	for i := 0; i < threads; i++ {
+		src := touchBlocks(make([]Block, bufferSize/blockSize))
+		var dst []Block
+		if kernel == KernelCopy {
+			dst = touchBlocks(make([]Block, bufferSize/blockSize))
+		}
+		go stream(kernel, asBytes(src), asBytes(dst), bandwidth*1e9/float64(threads), 0, &streamed)
+	}
+	target := "unlimited"
+	if bandwidth > 0 {
+		target = fmt.Sprintf("%gGB/s", bandwidth)
+	}
+	log.Infof(ctx, "%d %s threads are streaming over %dMB buffers, target bandwidth: %s", threads, kernel, bufferSize>>20, target)
+
+	ticker := time.NewTicker(memReportInterval)
+	defer ticker.Stop()
+	last, lastStreamed := time.Now(), uint64(0)
+	for now := range ticker.C {
+		s := atomic.LoadUint64(&streamed)
+		log.Infof(ctx, "mem bandwidth: %.2fGB/s", float64(s-lastStreamed)/now.Sub(last).Seconds()/1e9)
+		last, lastStreamed = now, s
+	}
+	return spec.ReturnSuccess(ctx.Value(spec.Uid))
+}
+
+// stream runs the kernel over the buffers chunk by chunk for the passes, 0 means forever, and sleeps
+// if it is ahead of the rate, the rate is bytes per second, 0 means unlimited. The copy kernel
+// counts the bytes read and written. The buffers are aligned to whole chunks by alignBufferSize.
+func stream(kernel string, src, dst []byte, rate float64, passes int, streamed *uint64) {
+	runtime.LockOSThread()
+	words := unsafe.Slice((*uint64)(unsafe.Pointer(&src[0])), len(src)/8)
+	chunks := len(src) / bandwidthChunk
+	var sink uint64
+	var total float64
+	begin := time.Now()
+	for i := 0; passes == 0 || i < passes*chunks; i++ {
+		offset := i % chunks * bandwidthChunk
+		n := bandwidthChunk
+		switch kernel {
+		case KernelCopy:
+			copy(dst[offset:offset+n], src[offset:offset+n])
+			n *= 2
+		case KernelRead:
+			for _, w := range words[offset/8 : (offset+n)/8] {
+				sink += w
+			}
+		case KernelWrite:
+			chunk := words[offset/8 : (offset+n)/8]
+			for i := range chunk {
+				chunk[i] = sink
+			}
+			sink++
+		}
+		atomic.AddUint64(streamed, uint64(n))
+		if rate > 0 {
+			total += float64(n)
+			if ahead := time.Duration(total/rate*float64(time.Second)) - time.Since(begin); ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+	}
+}
+
+// alignBufferSize rounds the buffer size up to the whole chunks, so that the kernels always
+// stream full chunks, and the size is a multiple of the block size
+func alignBufferSize(size int) int {
+	if size < bandwidthChunk {
+		return bandwidthChunk
+	}
+	return (size + bandwidthChunk - 1) / bandwidthChunk * bandwidthChunk
+}
+
+func asBytes(blocks []Block) []byte {
+	if len(blocks) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&blocks[0])), len(blocks)*blockSize)
+}
+
+// lastLevelCacheSize returns the size in bytes of the cache with the highest level
+func lastLevelCacheSize(cachePath string) (int, error) {
+	indexes, err := filepath.Glob(filepath.Join(cachePath, "index*"))
+	if err != nil {
+		return 0, err
+	}
+	var maxLevel, size int
+	for _, index := range indexes {
+		levelBytes, err := os.ReadFile(filepath.Join(index, "level"))
+		if err != nil {
+			continue
+		}
+		sizeBytes, err := os.ReadFile(filepath.Join(index, "size"))
+		if err != nil {
+			continue
+		}
+		level, err := strconv.Atoi(strings.TrimSpace(string(levelBytes)))
+		if err != nil || level < maxLevel {
+			continue
+		}
+		s, err := parseCacheSize(strings.TrimSpace(string(sizeBytes)))
+		if err != nil {
+			continue
+		}
+		maxLevel, size = level, s
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("no cache is found in %s", cachePath)
+	}
+	return size, nil
+}
+
+// parseCacheSize parses the cache size of sysfs, such as 32K or 8M
+func parseCacheSize(size string) (int, error) {
+	unit := 1
+	switch {
+	case strings.HasSuffix(size, "K"):
+		unit, size = 1<<10, strings.TrimSuffix(size, "K")
+	case strings.HasSuffix(size, "M"):
+		unit, size = 1<<20, strings.TrimSuffix(size, "M")
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+)
+
+const cacheAction = "mem-load-cache"
+
+var dirName = "burnmem_tmpfs"
+
+var fileName = "file"
+
+// cacheRecord is the tmpfs mounted by the cache mode of the experiment
+type cacheRecord struct {
+	Dir string `json:"dir"`
+}
+
+// burnMemWithCache fills a dedicated tmpfs by direct writes of the rate per second, the size of the
+// tmpfs is capped to the memory expected at the start, so the cache never grows beyond it
+func burnMemWithCache(ctx context.Context, uid string, memPercent, memReserve, memRate, memSize int, burnMemMode string,
+	includeBufferCache bool, cl spec.Channel) *spec.Response {
+	expectMem, err := expectMemSize(ctx, burnMemMode, memPercent, memReserve, memSize, 0, includeBufferCache)
+	if err != nil {
+		log.Errorf(ctx, "calculate memsize err, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "calculate memsize", err)
+	}
+	if expectMem <= 0 {
+		log.Errorf(ctx, "no memory to burn, expect mem: %dMB", expectMem)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "burn mem with cache", "no memory to burn")
+	}
+	dir, err := mountCacheTmpfs(ctx, cl, uid, expectMem)
+	if err != nil {
+		log.Errorf(ctx, "mount tmpfs failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "mount tmpfs", err)
+	}
+	file, err := os.OpenFile(filepath.Join(localPath(ctx, cl, dir), fileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Errorf(ctx, "open the tmpfs file failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "open the tmpfs file", err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, 1024*1024)
+	var filled int64
+	full := false
+	for range time.Tick(time.Second) {
+		if full {
+			continue
+		}
+		expectMem, err := expectMemSize(ctx, burnMemMode, memPercent, memReserve, memSize, filled, includeBufferCache)
+		if err != nil {
+			log.Fatalf(ctx, "calculate memsize err, %v", err)
+		}
+		fillMem := expectMem
+		if fillMem > int64(memRate) {
+			fillMem = int64(memRate)
+		}
+		for i := int64(0); i < fillMem; i++ {
+			if _, err := file.Write(buf); err != nil {
+				if errors.Is(err, syscall.ENOSPC) {
+					log.Infof(ctx, "the size cap of the tmpfs is reached, %dMB filled", filled)
+					full = true
+					break
+				}
+				log.Fatalf(ctx, "burn mem with cache err, %v", err)
+			}
+			filled++
+		}
+		log.Debugf(ctx, "filled: %d, expect mem: %d, rate: %d", filled, expectMem, memRate)
+	}
+	return spec.Success()
+}
+
+// mountCacheTmpfs mounts a tmpfs of the size in MB for the experiment uid, the mount is recorded,
+// so that it is unmounted on destroy
+func mountCacheTmpfs(ctx context.Context, cl spec.Channel, uid string, size int64) (string, error) {
+	dir := filepath.Join(util.GetProgramPath(), fmt.Sprintf("%s-%s", dirName, uid))
+	if err := exec.SaveRecord(cacheAction, uid, &cacheRecord{Dir: dir}); err != nil {
+		return "", err
+	}
+	if response := cl.Run(ctx, "mkdir", "-p "+dir); !response.Success {
+		exec.RemoveRecord(cacheAction, uid)
+		return "", fmt.Errorf("mkdir %s failed, %s", dir, response.Err)
+	}
+	options := fmt.Sprintf("size=%dm", size)
+	if node := numaNode(ctx); node >= 0 {
+		options = fmt.Sprintf("%s,mpol=bind:%d", options, node)
+	}
+	if response := cl.Run(ctx, "mount", fmt.Sprintf("-t tmpfs -o %s tmpfs %s", options, dir)); !response.Success {
+		cl.Run(ctx, "rmdir", dir)
+		exec.RemoveRecord(cacheAction, uid)
+		return "", fmt.Errorf("mount tmpfs on %s failed, %s", dir, response.Err)
+	}
+	log.Infof(ctx, "mount tmpfs on %s, options: %s", dir, options)
+	return dir, nil
+}
+
+// umountCacheTmpfs unmounts and removes the tmpfs of the experiment uid, the unmount is retried
+// while the killed burn process is exiting
+func umountCacheTmpfs(ctx context.Context, cl spec.Channel, uid string) error {
+	var record cacheRecord
+	if err := exec.LoadRecord(cacheAction, uid, &record); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// the experiment is not in the cache mode
+			return nil
+		}
+		return err
+	}
+	var response *spec.Response
+	for i := 0; i < 10; i++ {
+		if response = cl.Run(ctx, "umount", record.Dir); response.Success {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !response.Success {
+		return fmt.Errorf("umount %s failed, %s", record.Dir, response.Err)
+	}
+	if response := cl.Run(ctx, "rmdir", record.Dir); !response.Success {
+		log.Warnf(ctx, "rmdir %s failed, %s", record.Dir, response.Err)
+	}
+	log.Infof(ctx, "umount tmpfs on %s", record.Dir)
+	return exec.RemoveRecord(cacheAction, uid)
+}
+
+// localPath returns the path of the dir in the channel as seen by the current process, the mount
+// namespace of the nsexec target is reached by /proc/$PID/root
+func localPath(ctx context.Context, cl spec.Channel, dir string) string {
+	if _, ok := cl.(*channel.NSExecChannel); !ok || ctx.Value(channel.NSMntFlagName) != spec.True {
+		return dir
+	}
+	if target, ok := ctx.Value(channel.NSTargetFlagName).(string); ok && target != "" {
+		return filepath.Join("/proc", target, "root", dir)
+	}
+	return dir
+}
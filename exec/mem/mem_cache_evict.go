@@ -0,0 +1,211 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/shirou/gopsutil/mem"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const dropCachesFile = "/proc/sys/vm/drop_caches"
+
+type CacheEvictActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewCacheEvictActionCommandSpec() spec.ExpActionCommandSpec {
+	return &CacheEvictActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "path",
+					Desc: "The file or directory whose page cache is evicted by posix_fadvise(POSIX_FADV_DONTNEED), the files under the directory are evicted recursively",
+				},
+				&spec.ExpFlag{
+					Name: "drop-caches",
+					Desc: "The value written to /proc/sys/vm/drop_caches, 1 drops the page cache, 2 drops the dentries and inodes, 3 drops both",
+				},
+				&spec.ExpFlag{
+					Name: "interval",
+					Desc: "The interval of the eviction in seconds, default value is 5",
+				},
+			},
+			ActionExecutor: &cacheEvictExecutor{},
+			ActionExample: `
+# Evict the page cache of the files under /var/lib/mysql every 5 seconds
+blade create mem cache-evict --path /var/lib/mysql
+
+# Drop the page cache of the host every second
+blade create mem cache-evict --drop-caches 1 --interval 1`,
+			ActionCategories:  []string{category.SystemMem},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*CacheEvictActionCommandSpec) Name() string {
+	return "cache-evict"
+}
+
+func (*CacheEvictActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*CacheEvictActionCommandSpec) ShortDesc() string {
+	return "page cache evict"
+}
+
+func (c *CacheEvictActionCommandSpec) LongDesc() string {
+	if c.ActionLongDesc != "" {
+		return c.ActionLongDesc
+	}
+	return "Evict the page cache of the files under a path, or drop the caches of the host, repeatedly at an interval, " +
+		"so that the reads of databases and search indexes are cold. The dirty pages are not evicted until they are written back"
+}
+
+type cacheEvictExecutor struct {
+	channel spec.Channel
+}
+
+func (ce *cacheEvictExecutor) Name() string {
+	return "cache-evict"
+}
+
+func (ce *cacheEvictExecutor) SetChannel(channel spec.Channel) {
+	ce.channel = channel
+}
+
+func (ce *cacheEvictExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if ce.channel == nil {
+		log.Errorf(ctx, spec.ChannelNil.Msg)
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return exec.Destroy(ctx, ce.channel, "mem cache-evict")
+	}
+
+	path := model.ActionFlags["path"]
+	dropCaches := model.ActionFlags["drop-caches"]
+	if path == "" && dropCaches == "" {
+		log.Errorf(ctx, "path or drop-caches is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "path|drop-caches")
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			log.Errorf(ctx, "`%s`: path is illegal, %v", path, err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "path", path, err)
+		}
+	}
+	if dropCaches != "" && dropCaches != "1" && dropCaches != "2" && dropCaches != "3" {
+		log.Errorf(ctx, "`%s`: drop-caches must be 1, 2 or 3", dropCaches)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "drop-caches", dropCaches, "it must be 1, 2 or 3")
+	}
+	interval := 5
+	if intervalStr := model.ActionFlags["interval"]; intervalStr != "" {
+		var err error
+		interval, err = strconv.Atoi(intervalStr)
+		if err != nil || interval <= 0 {
+			log.Errorf(ctx, "`%s`: interval must be a positive integer", intervalStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "interval", intervalStr, "it must be a positive integer")
+		}
+	}
+
+	// fail fast before holding, the later failures are only logged
+	if err := evictCache(ctx, path, dropCaches); err != nil {
+		log.Errorf(ctx, "evict page cache failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "evict page cache", err)
+	}
+	ce.start(ctx, path, dropCaches, time.Duration(interval)*time.Second)
+	return spec.Success()
+}
+
+// start evicts the page cache at the interval until the process is killed
+func (ce *cacheEvictExecutor) start(ctx context.Context, path, dropCaches string, interval time.Duration) {
+	lastReport := time.Now()
+	for now := range time.Tick(interval) {
+		if err := evictCache(ctx, path, dropCaches); err != nil {
+			log.Warnf(ctx, "evict page cache failed, %v", err)
+		}
+		if now.Sub(lastReport) >= memReportInterval {
+			if vm, err := mem.VirtualMemory(); err == nil {
+				log.Infof(ctx, "page cache of the host: %dMB", vm.Cached>>20)
+			}
+			lastReport = now
+		}
+	}
+}
+
+// evictCache evicts the page cache of the files under the path, and writes drop_caches
+func evictCache(ctx context.Context, path, dropCaches string) error {
+	if path != "" {
+		files, bytes, err := evictPath(ctx, path)
+		if err != nil {
+			return err
+		}
+		log.Debugf(ctx, "evict page cache of %d files, %d bytes", files, bytes)
+	}
+	if dropCaches != "" {
+		if err := os.WriteFile(dropCachesFile, []byte(dropCaches), 0644); err != nil { //nolint:gosec
+			return err
+		}
+		log.Debugf(ctx, "write %s to %s", dropCaches, dropCachesFile)
+	}
+	return nil
+}
+
+// evictPath evicts the page cache of the regular files under the path, the files failed to
+// open are skipped. It returns the count and the total size of the files evicted.
+func evictPath(ctx context.Context, path string) (int, int64, error) {
+	var files int
+	var bytes int64
+	err := filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == path {
+				return err
+			}
+			log.Debugf(ctx, "skip %s, %v", name, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if err := fadviseDontNeed(name); err != nil {
+			log.Debugf(ctx, "skip %s, %v", name, err)
+			return nil
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return files, bytes, err
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+type HugepageExhaustActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewHugepageExhaustActionCommandSpec() spec.ExpActionCommandSpec {
+	return &HugepageExhaustActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "count",
+					Desc: "The number of free huge pages to reserve, all free huge pages are reserved if it is not set",
+				},
+			},
+			ActionExecutor: &hugepageExhaustExecutor{},
+			ActionExample: `
+# Reserve all free huge pages, the applications depending on huge pages fail to allocate
+blade create mem hugepage-exhaust
+
+# Reserve 512 free huge pages
+blade create mem hugepage-exhaust --count 512`,
+			ActionCategories:  []string{category.SystemMem},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*HugepageExhaustActionCommandSpec) Name() string {
+	return "hugepage-exhaust"
+}
+
+func (*HugepageExhaustActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*HugepageExhaustActionCommandSpec) ShortDesc() string {
+	return "huge page exhaust"
+}
+
+func (h *HugepageExhaustActionCommandSpec) LongDesc() string {
+	if h.ActionLongDesc != "" {
+		return h.ActionLongDesc
+	}
+	return "Reserve the free huge pages of the default size by MAP_HUGETLB, so that the applications depending on huge pages fail to allocate. The huge pages are released when the experiment is destroyed"
+}
+
+type hugepageExhaustExecutor struct {
+	channel spec.Channel
+}
+
+func (he *hugepageExhaustExecutor) Name() string {
+	return "hugepage-exhaust"
+}
+
+func (he *hugepageExhaustExecutor) SetChannel(channel spec.Channel) {
+	he.channel = channel
+}
+
+func (he *hugepageExhaustExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if he.channel == nil {
+		log.Errorf(ctx, spec.ChannelNil.Msg)
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return exec.Destroy(ctx, he.channel, "mem hugepage-exhaust")
+	}
+	var count int
+	if countStr := model.ActionFlags["count"]; countStr != "" {
+		var err error
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count <= 0 {
+			log.Errorf(ctx, "`%s`: count must be a positive integer", countStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "count", countStr, "it must be a positive integer")
+		}
+	}
+	return he.start(ctx, count)
+}
+
+// start maps and touches the free huge pages, and holds them until the process is killed
+func (he *hugepageExhaustExecutor) start(ctx context.Context, count int) *spec.Response {
+	_, free, pageSize, err := hugepageInfo()
+	if err != nil {
+		log.Errorf(ctx, "get huge page info failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get huge page info", err)
+	}
+	if count > 0 && uint64(count) > free {
+		log.Warnf(ctx, "only %d huge pages are free, %d are expected", free, count)
+	}
+	count, n, err := hugepageExhaustSize(count, free, pageSize)
+	if err != nil {
+		log.Errorf(ctx, "reserve huge pages failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "reserve huge pages", err)
+	}
+	blocks, err := allocBlocks("hugepage", n, -1)
+	if err != nil {
+		log.Errorf(ctx, "reserve %d huge pages failed, %v", count, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, fmt.Sprintf("reserve %d huge pages", count), err)
+	}
+	touchBlocks(blocks)
+	log.Infof(ctx, "%d huge pages of %dkB are reserved", count, pageSize/1024)
+	select {}
+}
+
+// hugepageExhaustSize returns the huge pages to reserve and the blocks mapped for them, all
+// the free huge pages are reserved if the count is 0 or more than the free ones
+func hugepageExhaustSize(count int, free, pageSize uint64) (int, int, error) {
+	if free == 0 {
+		return 0, 0, fmt.Errorf("no free huge page")
+	}
+	if count == 0 || uint64(count) > free {
+		count = int(free)
+	}
+	n := int(uint64(count) * pageSize / uint64(blockSize))
+	if n == 0 {
+		return 0, 0, fmt.Errorf("%d huge pages of %dkB are less than a block of %dkB", count, pageSize/1024, blockSize/1024)
+	}
+	return count, n, nil
+}
+
+// getHugepageAvailableAndTotal returns the total and free bytes of the huge page pool
+func getHugepageAvailableAndTotal() (int64, int64, error) {
+	total, free, pageSize, err := hugepageInfo()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(total * pageSize), int64(free * pageSize), nil
+}
+
+// hugepageFillSize rounds the fill size in MB down to whole huge pages,
+// at least one huge page is filled if the expected size is not less than a huge page
+func hugepageFillSize(fillMem, expectMem int64) (int64, error) {
+	_, _, pageSize, err := hugepageInfo()
+	if err != nil {
+		return 0, err
+	}
+	return roundHugepages(fillMem, expectMem, pageSize), nil
+}
+
+func roundHugepages(fillMem, expectMem int64, pageSize uint64) int64 {
+	pageMB := int64(pageSize / 1024 / 1024)
+	if pageMB == 0 {
+		return fillMem
+	}
+	fill := fillMem / pageMB * pageMB
+	if fill == 0 && expectMem >= pageMB {
+		fill = pageMB
+	}
+	return fill
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import "fmt"
+
+func allocBlocks(burnMemMode string, n, node int) ([]Block, error) {
+	if burnMemMode == "hugepage" || burnMemMode == "thp" {
+		return nil, fmt.Errorf("%s mode is not supported on darwin", burnMemMode)
+	}
+	if node >= 0 {
+		return nil, fmt.Errorf("numa node is not supported on darwin")
+	}
+	return make([]Block, n), nil
+}
+
+func hugepageInfo() (uint64, uint64, uint64, error) {
+	return 0, 0, 0, fmt.Errorf("huge page is not supported on darwin")
+}
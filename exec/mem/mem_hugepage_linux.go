@@ -0,0 +1,121 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// mpolBind is MPOL_BIND of mbind
+const mpolBind = 2
+
+// allocBlocks allocates the blocks, the memory is mapped by MAP_HUGETLB for the hugepage mode,
+// and advised by MADV_HUGEPAGE for the thp mode. It is bound to the numa node by mbind if the
+// node is not negative. The mapped memory is never unmapped, it is released when the process exits.
+func allocBlocks(burnMemMode string, n, node int) ([]Block, error) {
+	flags, advise, mapped := mapFlags(burnMemMode, node)
+	if n <= 0 || !mapped {
+		return make([]Block, n), nil
+	}
+	b, err := syscall.Mmap(-1, 0, n*blockSize, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+	if err != nil {
+		return nil, err
+	}
+	if advise {
+		if err := syscall.Madvise(b, syscall.MADV_HUGEPAGE); err != nil {
+			syscall.Munmap(b)
+			return nil, fmt.Errorf("madvise MADV_HUGEPAGE failed, %v", err)
+		}
+	}
+	if node >= 0 {
+		if err := mbind(b, node); err != nil {
+			syscall.Munmap(b)
+			return nil, fmt.Errorf("mbind to numa node %d failed, %v", node, err)
+		}
+	}
+	return unsafe.Slice((*Block)(unsafe.Pointer(&b[0])), n), nil
+}
+
+// mapFlags returns the mmap flags of the mode and whether the mapping is advised by MADV_HUGEPAGE,
+// mapped is false if the blocks are allocated from the go heap
+func mapFlags(burnMemMode string, node int) (flags int, advise bool, mapped bool) {
+	flags = syscall.MAP_PRIVATE | syscall.MAP_ANONYMOUS
+	switch burnMemMode {
+	case "hugepage":
+		return flags | syscall.MAP_HUGETLB, false, true
+	case "thp":
+		return flags, true, true
+	}
+	// the heap memory cannot be bound to the numa node
+	return flags, false, node >= 0
+}
+
+// mbind binds the untouched mapping to the numa node by MPOL_BIND, the policy belongs to the
+// mapping, so the pages are allocated from the node whichever thread touches them
+func mbind(b []byte, node int) error {
+	mask := make([]uint64, node/64+1)
+	mask[node/64] |= 1 << (node % 64)
+	// the kernel takes maxnode as the mask bits plus one
+	_, _, errno := syscall.Syscall6(syscall.SYS_MBIND, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), mpolBind,
+		uintptr(unsafe.Pointer(&mask[0])), uintptr(len(mask)*64+1), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// hugepageInfo returns HugePages_Total, HugePages_Free and Hugepagesize in bytes from /proc/meminfo
+func hugepageInfo() (uint64, uint64, uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+	var total, free, pageSize uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "HugePages_Total:":
+			total = value
+		case "HugePages_Free:":
+			free = value
+		case "Hugepagesize:":
+			pageSize = value * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+	if pageSize == 0 {
+		return 0, 0, 0, fmt.Errorf("huge page is not supported")
+	}
+	return total, free, pageSize, nil
+}
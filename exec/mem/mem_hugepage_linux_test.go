@@ -0,0 +1,59 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestMapFlags(t *testing.T) {
+	anonymous := syscall.MAP_PRIVATE | syscall.MAP_ANONYMOUS
+	tests := []struct {
+		mode           string
+		node           int
+		flags          int
+		advise, mapped bool
+	}{
+		{"ram", -1, anonymous, false, false},
+		{"", -1, anonymous, false, false},
+		{"ram", 1, anonymous, false, true},
+		{"hugepage", -1, anonymous | syscall.MAP_HUGETLB, false, true},
+		{"hugepage", 0, anonymous | syscall.MAP_HUGETLB, false, true},
+		{"thp", -1, anonymous, true, true},
+		{"thp", 0, anonymous, true, true},
+	}
+	for _, tt := range tests {
+		flags, advise, mapped := mapFlags(tt.mode, tt.node)
+		if flags != tt.flags || advise != tt.advise || mapped != tt.mapped {
+			t.Errorf("mapFlags(%q, %d) = %#x, %v, %v, expected %#x, %v, %v",
+				tt.mode, tt.node, flags, advise, mapped, tt.flags, tt.advise, tt.mapped)
+		}
+	}
+	// the heap blocks and the mapped blocks are both usable
+	for _, tt := range tests {
+		if tt.mode == "hugepage" || tt.node > 0 {
+			continue
+		}
+		blocks, err := allocBlocks(tt.mode, 2, tt.node)
+		if err != nil || len(blocks) != 2 {
+			t.Errorf("allocBlocks(%q, 2, %d) = %d blocks, %v", tt.mode, tt.node, len(blocks), err)
+			continue
+		}
+		touchBlocks(blocks)
+	}
+}
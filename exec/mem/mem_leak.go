@@ -0,0 +1,290 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const (
+	GrowthLinear      = "linear"
+	GrowthExponential = "exponential"
+
+	ReleaseNone     = "none"
+	ReleaseSawtooth = "sawtooth"
+)
+
+type MemLeakActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewMemLeakActionCommandSpec() spec.ExpActionCommandSpec {
+	return &MemLeakActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "cap",
+					Desc: "The cap of the leaked memory, unit is MB, G or GB suffix is supported, such as 512 or 2G",
+				},
+				&spec.ExpFlag{
+					Name: "duration",
+					Desc: "Duration(s) to grow up to the cap, default value is 600",
+				},
+				&spec.ExpFlag{
+					Name: "growth",
+					Desc: "The growth curve, linear or exponential, default value is linear",
+				},
+				&spec.ExpFlag{
+					Name: "release",
+					Desc: "The release policy when the cap or the threshold is reached, none holds the memory, sawtooth releases everything and grows again like GC cycles, default value is none",
+				},
+				&spec.ExpFlag{
+					Name: "threshold",
+					Desc: "Percent (1-100) of the cgroup memory.high or memory.max, the growth stops when the cgroup usage reaches it. The leak process joins the cgroup of the target pid of the nsexec channel, otherwise the cgroup of the leak process is used",
+				},
+				&spec.ExpFlag{
+					Name:   "join-cgroup",
+					Desc:   "Move the leak process into the cgroup of the target pid of the nsexec channel, so that the leak is accounted against the container limits",
+					NoArgs: true,
+				},
+			},
+			ActionExecutor: &memLeakExecutor{},
+			ActionExample: `
+# Leak 2G memory linearly in 30 minutes and hold it
+blade create mem leak --cap 2G --duration 1800
+
+# Leak up to 1G memory exponentially in 10 minutes, then release everything and leak again
+blade create mem leak --cap 1G --growth exponential --release sawtooth
+
+# Leak memory until the cgroup usage reaches 90% of memory.high or memory.max
+blade create mem leak --cap 8G --threshold 90`,
+			ActionCategories:  []string{category.SystemMem},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*MemLeakActionCommandSpec) Name() string {
+	return "leak"
+}
+
+func (*MemLeakActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*MemLeakActionCommandSpec) ShortDesc() string {
+	return "mem leak"
+}
+
+func (l *MemLeakActionCommandSpec) LongDesc() string {
+	if l.ActionLongDesc != "" {
+		return l.ActionLongDesc
+	}
+	return "Leak memory linearly or exponentially over a duration up to a cap, to reproduce slow leak incidents. " +
+		"The memory is held, or released periodically like GC cycles when the cap or the cgroup threshold is reached"
+}
+
+type memLeakExecutor struct {
+	channel spec.Channel
+}
+
+func (le *memLeakExecutor) Name() string {
+	return "leak"
+}
+
+func (le *memLeakExecutor) SetChannel(channel spec.Channel) {
+	le.channel = channel
+}
+
+// memLeak is the growth curve of the leak
+type memLeak struct {
+	growth    string
+	release   string
+	cap       int
+	duration  time.Duration
+	threshold int
+}
+
+// target returns the leaked memory in MB expected after the elapsed time, the exponential
+// growth starts from 1MB and multiplies at a constant rate to reach the cap at the duration
+func (l *memLeak) target(elapsed time.Duration) int64 {
+	ratio := float64(elapsed) / float64(l.duration)
+	if ratio >= 1 {
+		return int64(l.cap)
+	}
+	if l.growth == GrowthExponential {
+		return int64(math.Pow(float64(l.cap), ratio))
+	}
+	return int64(float64(l.cap) * ratio)
+}
+
+func (le *memLeakExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if le.channel == nil {
+		log.Errorf(ctx, spec.ChannelNil.Msg)
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return exec.Destroy(ctx, le.channel, "mem leak")
+	}
+
+	leak := &memLeak{growth: GrowthLinear, release: ReleaseNone, duration: 600 * time.Second}
+	capStr := model.ActionFlags["cap"]
+	if capStr == "" {
+		log.Errorf(ctx, "cap is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "cap")
+	}
+	var err error
+	if leak.cap, err = parseMemSize(capStr); err != nil {
+		log.Errorf(ctx, "`%s`: cap is illegal, %v", capStr, err)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "cap", capStr, err)
+	}
+	if durationStr := model.ActionFlags["duration"]; durationStr != "" {
+		duration, err := strconv.Atoi(durationStr)
+		if err != nil || duration <= 0 {
+			log.Errorf(ctx, "`%s`: duration must be a positive integer", durationStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "duration", durationStr, "it must be a positive integer")
+		}
+		leak.duration = time.Duration(duration) * time.Second
+	}
+	if growth := model.ActionFlags["growth"]; growth != "" {
+		if growth != GrowthLinear && growth != GrowthExponential {
+			log.Errorf(ctx, "`%s`: growth must be linear or exponential", growth)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "growth", growth, "it must be linear or exponential")
+		}
+		leak.growth = growth
+	}
+	if release := model.ActionFlags["release"]; release != "" {
+		if release != ReleaseNone && release != ReleaseSawtooth {
+			log.Errorf(ctx, "`%s`: release must be none or sawtooth", release)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "release", release, "it must be none or sawtooth")
+		}
+		leak.release = release
+	}
+
+	if thresholdStr := model.ActionFlags["threshold"]; thresholdStr != "" {
+		leak.threshold, err = strconv.Atoi(thresholdStr)
+		if err != nil || leak.threshold <= 0 || leak.threshold > 100 {
+			log.Errorf(ctx, "`%s`: threshold must be a positive integer and not bigger than 100", thresholdStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "threshold", thresholdStr, "it must be a positive integer and not bigger than 100")
+		}
+	}
+	cgroup, resp := leakCgroup(ctx, model, leak.threshold > 0)
+	if resp != nil {
+		return resp
+	}
+	le.start(ctx, leak, cgroup)
+	return spec.Success()
+}
+
+// leakCgroup joins the cgroup of the nsexec target pid for join-cgroup or the threshold, otherwise the
+// leaked memory is not charged to the target cgroup, and the threshold is only reached by the target
+// itself. The cgroup whose usage is compared with the threshold is returned.
+func leakCgroup(ctx context.Context, model *spec.ExpModel, threshold bool) (*exec.Cgroup, *spec.Response) {
+	target := model.ActionFlags[channel.NSTargetFlagName]
+	if model.ActionFlags["join-cgroup"] == spec.True || (threshold && target != "") {
+		if resp := exec.JoinTargetCgroup(ctx, model, "memory"); resp != nil {
+			return nil, resp
+		}
+	}
+	if !threshold {
+		return nil, nil
+	}
+	pid := os.Getpid()
+	if target != "" {
+		var err error
+		if pid, err = strconv.Atoi(target); err != nil {
+			return nil, spec.ResponseFailWithFlags(spec.ParameterIllegal, channel.NSTargetFlagName, target, err)
+		}
+	}
+	cgroup, err := exec.LoadCgroup(model.ActionFlags["cgroup-root"], pid)
+	if err != nil {
+		log.Errorf(ctx, "load cgroup of pid %d failed, %v", pid, err)
+		return nil, spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load cgroup", err)
+	}
+	return cgroup, nil
+}
+
+// start leaks memory in the tick loop of mem load
+func (le *memLeakExecutor) start(ctx context.Context, leak *memLeak, cgroup *exec.Cgroup) {
+	var cache [][]Block
+	var filled int64
+	begin, holding := time.Now(), false
+	tick := time.Tick(time.Second)
+	for now := range tick {
+		// the hold is latched, otherwise the growth resumes after the reclaim of memory.high brings
+		// the usage back under the threshold, and the whole lag is allocated in one tick
+		if holding {
+			continue
+		}
+		reached := filled >= int64(leak.cap)
+		if !reached && cgroup != nil {
+			var err error
+			if reached, err = thresholdReached(cgroup, leak.threshold); err != nil {
+				log.Warnf(ctx, "get cgroup memory stat failed, %v", err)
+			}
+		}
+		if reached {
+			if leak.release == ReleaseSawtooth {
+				log.Infof(ctx, "release %dMB leaked memory", filled)
+				cache, filled, begin = nil, 0, now
+				// return the memory to the os, otherwise the rss is not decreased
+				debug.FreeOSMemory()
+				continue
+			}
+			log.Infof(ctx, "hold %dMB leaked memory", filled)
+			holding = true
+			continue
+		}
+
+		fillMem := leak.target(now.Sub(begin)) - filled
+		if fillMem <= 0 {
+			continue
+		}
+		cache = append(cache, touchBlocks(make([]Block, 8*fillMem)))
+		filled += fillMem
+		log.Debugf(ctx, "leaked: %dMB, cap: %dMB", filled, leak.cap)
+	}
+}
+
+// thresholdReached returns true if the cgroup usage reaches the threshold percent of memory.high or memory.max
+func thresholdReached(cgroup *exec.Cgroup, threshold int) (bool, error) {
+	stat, err := cgroup.MemoryStat()
+	if err != nil {
+		return false, err
+	}
+	limit := stat.Limit
+	if stat.High < limit {
+		limit = stat.High
+	}
+	if limit >= PageCounterMax {
+		return false, nil
+	}
+	return stat.Usage >= limit/100*uint64(threshold), nil
+}
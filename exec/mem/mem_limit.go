@@ -0,0 +1,265 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const limitAction = "mem-limit"
+
+// minMemLimit is the smallest limit accepted, a limit near 0 makes the cgroup unable to run at all
+const minMemLimit = 4 << 20
+
+const (
+	LimitHigh = "high"
+	LimitMax  = "max"
+)
+
+type MemLimitActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewMemLimitActionCommandSpec() spec.ExpActionCommandSpec {
+	return &MemLimitActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "pid",
+					Desc: "The pid of the target process, the memory cgroup of the process is squeezed. The target pid of the nsexec channel is used if it is empty",
+				},
+			},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "limit",
+					Desc: "The limit to lower, high lowers memory.high to throttle and reclaim, max lowers memory.max or memory.limit_in_bytes to trigger the cgroup oom, default value is max. high is only supported by cgroup v2",
+				},
+				&spec.ExpFlag{
+					Name: "value",
+					Desc: "The new limit, unit is MB, G or GB suffix is supported, such as 512 or 2G",
+				},
+				&spec.ExpFlag{
+					Name: "percent",
+					Desc: "The new limit in percent (1-100) of the current usage of the cgroup",
+				},
+			},
+			ActionExecutor: &memLimitExecutor{},
+			ActionExample: `
+# Lower the memory.high of the cgroup of the process 1234 to 80% of its usage, the cgroup is throttled and reclaimed
+blade create mem limit --pid 1234 --limit high --percent 80
+
+# Lower the memory limit of the container to 256M to trigger the cgroup oom
+blade create mem limit --channel nsexec --ns_target 1234 --limit max --value 256M`,
+			ActionCategories: []string{category.SystemMem},
+		},
+	}
+}
+
+func (*MemLimitActionCommandSpec) Name() string {
+	return "limit"
+}
+
+func (*MemLimitActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*MemLimitActionCommandSpec) ShortDesc() string {
+	return "mem limit"
+}
+
+func (l *MemLimitActionCommandSpec) LongDesc() string {
+	if l.ActionLongDesc != "" {
+		return l.ActionLongDesc
+	}
+	return "Lower the memory.high, memory.max or memory.limit_in_bytes of the target process cgroup to trigger the reclaim or the cgroup oom, " +
+		"and restore the original limit when the experiment is destroyed"
+}
+
+type memLimitExecutor struct {
+	channel spec.Channel
+}
+
+func (le *memLimitExecutor) Name() string {
+	return "limit"
+}
+
+func (le *memLimitExecutor) SetChannel(channel spec.Channel) {
+	le.channel = channel
+}
+
+// limitRecord is the original memory limit of the cgroup
+type limitRecord struct {
+	Cgroup   *exec.Cgroup `json:"cgroup"`
+	File     string       `json:"file"`
+	Value    string       `json:"value"`
+	OomKills uint64       `json:"oomKills"`
+}
+
+// LimitResult is the count of the processes killed by the cgroup oom killer during the experiment
+type LimitResult struct {
+	OomKills uint64 `json:"oomKills"`
+}
+
+func (le *memLimitExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if le.channel == nil {
+		log.Errorf(ctx, spec.ChannelNil.Msg)
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return le.stop(ctx, uid)
+	}
+
+	pidStr := model.ActionFlags["pid"]
+	if pidStr == "" {
+		pidStr = model.ActionFlags[channel.NSTargetFlagName]
+	}
+	if pidStr == "" {
+		log.Errorf(ctx, "pid is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "pid")
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		log.Errorf(ctx, "`%s`: pid is illegal, it must be a positive integer", pidStr)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "pid", pidStr, "it must be a positive integer")
+	}
+
+	limit := model.ActionFlags["limit"]
+	if limit == "" {
+		limit = LimitMax
+	}
+	if limit != LimitHigh && limit != LimitMax {
+		log.Errorf(ctx, "`%s`: limit must be high or max", limit)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "limit", limit, "it must be high or max")
+	}
+
+	sizeStr := model.ActionFlags["value"]
+	percentStr := model.ActionFlags["percent"]
+	var size, percent int
+	if sizeStr != "" {
+		if size, err = parseMemSize(sizeStr); err != nil {
+			log.Errorf(ctx, "`%s`: value is illegal, %v", sizeStr, err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "value", sizeStr, err)
+		}
+	} else if percentStr != "" {
+		percent, err = strconv.Atoi(percentStr)
+		if err != nil || percent <= 0 || percent > 100 {
+			log.Errorf(ctx, "`%s`: percent is illegal, it must be a positive integer and not bigger than 100", percentStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "percent", percentStr, "it must be a positive integer and not bigger than 100")
+		}
+	} else {
+		log.Errorf(ctx, "value or percent is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "value|percent")
+	}
+
+	cg, err := exec.LoadCgroup(model.ActionFlags["cgroup-root"], pid)
+	if err != nil {
+		log.Errorf(ctx, "load cgroup of pid %d failed, %v", pid, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load cgroup", err)
+	}
+	if limit == LimitHigh && cg.Version() != exec.CgroupV2 {
+		log.Errorf(ctx, "memory.high is only supported by cgroup v2")
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "limit", limit, "memory.high is only supported by cgroup v2")
+	}
+	return le.start(ctx, uid, cg, limit, size, percent)
+}
+
+func (le *memLimitExecutor) start(ctx context.Context, uid string, cg *exec.Cgroup, limit string, size, percent int) *spec.Response {
+	record := &limitRecord{Cgroup: cg, File: "memory.limit_in_bytes"}
+	if cg.Version() == exec.CgroupV2 {
+		record.File = "memory." + limit
+	}
+
+	newLimit := uint64(size) << 20
+	if percent > 0 {
+		stat, err := cg.MemoryStat()
+		if err != nil {
+			log.Errorf(ctx, "get memory stat failed, %v", err)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get memory stat", err)
+		}
+		newLimit = stat.Usage * uint64(percent) / 100
+	}
+	if newLimit < minMemLimit {
+		log.Errorf(ctx, "the new limit %d is less than %d bytes", newLimit, minMemLimit)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "value|percent", newLimit, fmt.Sprintf("the limit must not be less than %dMB", minMemLimit>>20))
+	}
+	value := strconv.FormatUint(newLimit, 10)
+
+	var err error
+	if record.Value, err = cg.ReadFile("memory", record.File); err != nil {
+		log.Errorf(ctx, "read %s failed, %v", record.File, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "read "+record.File, err)
+	}
+	// the limit must not be loosened, `max` of v2 and PageCounterMax of v1 are unlimited
+	if current, err := strconv.ParseUint(record.Value, 10, 64); err == nil && current < PageCounterMax && newLimit >= current {
+		log.Errorf(ctx, "the new limit %d is not less than the current %s %d", newLimit, record.File, current)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "value|percent", newLimit,
+			fmt.Sprintf("the limit must be less than the current %s %d", record.File, current))
+	}
+	if record.OomKills, err = cg.MemoryOomKills(); err != nil {
+		log.Warnf(ctx, "get the oom kill count failed, %v", err)
+	}
+	if err := exec.SaveRecord(limitAction, uid, record); err != nil {
+		log.Errorf(ctx, "save the original memory limit failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the original memory limit", err)
+	}
+	log.Infof(ctx, "limit memory, %s: %s -> %s", record.File, record.Value, value)
+	if err := cg.WriteFile("memory", record.File, value); err != nil {
+		exec.RemoveRecord(limitAction, uid)
+		log.Errorf(ctx, "write %s failed, %v", record.File, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "write "+record.File, err)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func (le *memLimitExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record limitRecord
+	if err := exec.LoadRecord(limitAction, uid, &record); err != nil {
+		log.Errorf(ctx, "load the original memory limit failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the original memory limit", err)
+	}
+	result := LimitResult{}
+	err := record.Cgroup.WriteFile("memory", record.File, record.Value)
+	switch {
+	case os.IsNotExist(err):
+		// the cgroup is removed with the container killed by the oom, there is nothing to restore
+		log.Infof(ctx, "the cgroup is removed, skip restoring %s", record.File)
+	case err != nil:
+		log.Errorf(ctx, "restore %s to %s failed, %v", record.File, record.Value, err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "restore "+record.File, err)
+	default:
+		log.Infof(ctx, "restore memory limit, %s: %s", record.File, record.Value)
+		if oomKills, err := record.Cgroup.MemoryOomKills(); err != nil {
+			log.Warnf(ctx, "get the oom kill count failed, %v", err)
+		} else if oomKills >= record.OomKills {
+			result.OomKills = oomKills - record.OomKills
+		}
+	}
+	if err := exec.RemoveRecord(limitAction, uid); err != nil {
+		log.Warnf(ctx, "remove the original memory limit record failed, %v", err)
+	}
+	return spec.ReturnSuccess(result)
+}
@@ -22,7 +22,6 @@ import (
     "github.com/chaosblade-io/chaosblade-exec-os/exec"
     "github.com/chaosblade-io/chaosblade-spec-go/channel"
     "github.com/chaosblade-io/chaosblade-spec-go/log"
-    "github.com/containerd/cgroups"
     "github.com/shirou/gopsutil/mem"
     "strconv"
 )
@@ -46,19 +45,19 @@ func getAvailableAndTotal(ctx context.Context, burnMemMode string, includeBuffer
 
         log.Debugf(ctx, "get mem useage by cgroup, root path: %s", cgroupRoot)
 
-        cgroup, err := cgroups.Load(exec.Hierarchy(cgroupRoot.(string)), exec.PidPath(p))
+        cgroup, err := exec.LoadCgroup(cgroupRoot.(string), p)
         if err != nil {
             return 0, 0, fmt.Errorf("load cgroup error, %v", err)
         }
-        stats, err := cgroup.Stat(cgroups.IgnoreNotExist)
+        stats, err := cgroup.MemoryStat()
         if err != nil {
             return 0, 0, fmt.Errorf("load cgroup stat error, %v", err)
         }
-        if stats != nil && stats.Memory.Usage.Limit < PageCounterMax {
-            total = int64(stats.Memory.Usage.Limit)
-            available = total - int64(stats.Memory.Usage.Usage)
+        if stats != nil && stats.Limit < PageCounterMax {
+            total = int64(stats.Limit)
+            available = total - int64(stats.Usage)
             if burnMemMode == "ram" && !includeBufferCache {
-                available = available + int64(stats.Memory.Cache)
+                available = available + int64(stats.Cache)
             }
             return total, available, nil
         }
@@ -0,0 +1,93 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+)
+
+// memReportInterval is the interval of logging the resident memory achieved
+const memReportInterval = 10 * time.Second
+
+// memLocker mlocks the burned buffers while the RLIMIT_MEMLOCK allows
+type memLocker struct {
+	// limit is the soft RLIMIT_MEMLOCK in bytes, 0 means unlimited
+	limit uint64
+	// privileged is true for root, which may lock beyond the limit by CAP_IPC_LOCK
+	privileged bool
+	locked     uint64
+	full       bool
+}
+
+// newMemLocker raises the soft RLIMIT_MEMLOCK to the hard limit
+func newMemLocker(ctx context.Context) *memLocker {
+	l := &memLocker{privileged: os.Geteuid() == 0}
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(rlimitMemlock, &rlimit); err != nil {
+		log.Warnf(ctx, "get RLIMIT_MEMLOCK failed, %v", err)
+		return l
+	}
+	if rlimit.Cur < rlimit.Max {
+		raised := syscall.Rlimit{Cur: rlimit.Max, Max: rlimit.Max}
+		if err := syscall.Setrlimit(rlimitMemlock, &raised); err != nil {
+			log.Warnf(ctx, "raise RLIMIT_MEMLOCK to %d failed, %v", rlimit.Max, err)
+		} else {
+			rlimit = raised
+		}
+	}
+	if rlimit.Cur != rlimInfinity {
+		l.limit = rlimit.Cur
+	}
+	log.Infof(ctx, "RLIMIT_MEMLOCK: %d", rlimit.Cur)
+	return l
+}
+
+// lock mlocks the buffer. The buffer beyond the RLIMIT_MEMLOCK is not locked, except for root,
+// which may be allowed by CAP_IPC_LOCK, the mlock failure is logged once and then ignored.
+func (l *memLocker) lock(ctx context.Context, blocks []Block) {
+	if l.full || len(blocks) == 0 {
+		return
+	}
+	size := uint64(len(blocks)) * uint64(unsafe.Sizeof(blocks[0]))
+	if l.limit > 0 && l.locked+size > l.limit && !l.privileged {
+		log.Warnf(ctx, "RLIMIT_MEMLOCK %d is reached, the memory burned later is not locked", l.limit)
+		l.full = true
+		return
+	}
+	if err := syscall.Mlock(unsafe.Slice((*byte)(unsafe.Pointer(&blocks[0])), size)); err != nil {
+		log.Warnf(ctx, "mlock failed after %d bytes locked, the memory burned later is not locked, %v", l.locked, err)
+		l.full = true
+		return
+	}
+	l.locked += size
+}
+
+// reportResidentMemory logs the resident and locked memory of the burn process
+func reportResidentMemory(ctx context.Context, filled int64) {
+	rss, locked, err := residentMemory()
+	if err != nil {
+		log.Warnf(ctx, "get the resident memory failed, %v", err)
+		return
+	}
+	log.Infof(ctx, "mem burned: %dMB, rss: %dMB, locked: %dMB", filled, rss/1024/1024, locked/1024/1024)
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"os"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+const (
+	rlimitMemlock = 6
+	rlimInfinity  = uint64(1<<63 - 1)
+)
+
+// residentMemory returns the rss of the current process, the locked memory is not reported on darwin
+func residentMemory() (uint64, uint64, error) {
+	p, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return 0, 0, err
+	}
+	info, err := p.MemoryInfo()
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.RSS, 0, nil
+}
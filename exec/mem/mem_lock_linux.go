@@ -0,0 +1,61 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	rlimitMemlock = 8
+	rlimInfinity  = ^uint64(0)
+)
+
+// residentMemory returns the VmRSS and VmLck of the current process in bytes
+func residentMemory() (uint64, uint64, error) {
+	status, err := procStatus()
+	if err != nil {
+		return 0, 0, err
+	}
+	return status["VmRSS"], status["VmLck"], nil
+}
+
+// procStatus returns the memory fields of /proc/self/status in bytes
+func procStatus() (map[string]uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	status := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[2] != "kB" {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		status[strings.TrimSuffix(fields[0], ":")] = value * 1024
+	}
+	return status, scanner.Err()
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const nodeSysfsPath = "/sys/devices/system/node"
+
+// numaNode returns the numa node which the memory is bound to, -1 means unbound
+func numaNode(ctx context.Context) int {
+	if node, ok := ctx.Value("numa-node").(int); ok {
+		return node
+	}
+	return -1
+}
+
+// checkNumaNode checks the numa node is online
+func checkNumaNode(node int) error {
+	if _, err := os.Stat(filepath.Join(nodeSysfsPath, fmt.Sprintf("node%d", node))); err != nil {
+		return fmt.Errorf("numa node %d not found", node)
+	}
+	return nil
+}
+
+// getNodeAvailableAndTotal returns the total and available bytes of the numa node, the file
+// pages are available for the ram mode like getAvailableAndTotal
+func getNodeAvailableAndTotal(node int, burnMemMode string, includeBufferCache bool) (int64, int64, error) {
+	meminfo, err := readNodeMeminfo(node)
+	if err != nil {
+		return 0, 0, err
+	}
+	if burnMemMode == "hugepage" {
+		_, _, pageSize, err := hugepageInfo()
+		if err != nil {
+			return 0, 0, err
+		}
+		return int64(meminfo["HugePages_Total"] * pageSize), int64(meminfo["HugePages_Free"] * pageSize), nil
+	}
+	total, available := int64(meminfo["MemTotal"]), int64(meminfo["MemFree"])
+	if burnMemMode == "ram" && !includeBufferCache {
+		available += int64(meminfo["FilePages"])
+	}
+	return total, available, nil
+}
+
+func readNodeMeminfo(node int) (map[string]uint64, error) {
+	bytes, err := os.ReadFile(filepath.Join(nodeSysfsPath, fmt.Sprintf("node%d", node), "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	return parseNodeMeminfo(string(bytes)), nil
+}
+
+// parseNodeMeminfo parses the lines like `Node 0 MemFree: 5279720 kB`, the sizes are
+// converted to bytes and the huge page counts are kept
+func parseNodeMeminfo(content string) map[string]uint64 {
+	meminfo := make(map[string]uint64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "Node" {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 4 && fields[4] == "kB" {
+			value *= 1024
+		}
+		meminfo[strings.TrimSuffix(fields[2], ":")] = value
+	}
+	return meminfo
+}
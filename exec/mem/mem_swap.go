@@ -0,0 +1,329 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+	"github.com/shirou/gopsutil/mem"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+)
+
+const swapAction = "mem-swap"
+
+const (
+	SwapFill    = "fill"
+	SwapDisable = "disable"
+)
+
+// swapChunk is the memory in MB allocated in each step of the swap fill
+const swapChunk = 64
+
+type MemSwapActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewMemSwapActionCommandSpec() spec.ExpActionCommandSpec {
+	return &MemSwapActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "swap-mode",
+					Desc: "fill or disable, fill pushes the anonymous memory into swap, disable runs swapoff on the swap devices, default value is fill",
+				},
+				&spec.ExpFlag{
+					Name: "swap-size",
+					Desc: "The memory to push into swap in the fill mode, unit is MB, G or GB suffix is supported, such as 512 or 2G",
+				},
+				&spec.ExpFlag{
+					Name: "swap-devices",
+					Desc: "The swap devices or files to swapoff in the disable mode, separated by comma, all active swap areas are disabled if it is not set",
+				},
+			},
+			ActionExecutor: &memSwapExecutor{},
+			ActionExample: `
+# Push 1G anonymous memory into swap
+blade create mem swap --swap-mode fill --swap-size 1G
+
+# Disable the swap file /swapfile, it is enabled with the original priority on destroy
+blade create mem swap --swap-mode disable --swap-devices /swapfile`,
+			ActionCategories:  []string{category.SystemMem},
+			ActionProcessHang: true,
+		},
+	}
+}
+
+func (*MemSwapActionCommandSpec) Name() string {
+	return "swap"
+}
+
+func (*MemSwapActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*MemSwapActionCommandSpec) ShortDesc() string {
+	return "swap pressure or swap off"
+}
+
+func (s *MemSwapActionCommandSpec) LongDesc() string {
+	if s.ActionLongDesc != "" {
+		return s.ActionLongDesc
+	}
+	return "Push the anonymous memory into swap by over-allocating and then idling, or run swapoff on the swap devices and files. " +
+		"The disabled swap areas are enabled with the original priorities when the experiment is destroyed"
+}
+
+type memSwapExecutor struct {
+	channel spec.Channel
+}
+
+func (se *memSwapExecutor) Name() string {
+	return "swap"
+}
+
+func (se *memSwapExecutor) SetChannel(channel spec.Channel) {
+	se.channel = channel
+}
+
+// swapArea is an active swap device or file in /proc/swaps
+type swapArea struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+}
+
+type swapRecord struct {
+	Areas []swapArea `json:"areas"`
+}
+
+func (se *memSwapExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if se.channel == nil {
+		log.Errorf(ctx, spec.ChannelNil.Msg)
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return se.stop(ctx, uid)
+	}
+
+	swapMode := model.ActionFlags["swap-mode"]
+	switch swapMode {
+	case "", SwapFill:
+		sizeStr := model.ActionFlags["swap-size"]
+		if sizeStr == "" {
+			log.Errorf(ctx, "swap-size is required")
+			return spec.ResponseFailWithFlags(spec.ParameterLess, "swap-size")
+		}
+		size, err := parseMemSize(sizeStr)
+		if err != nil {
+			log.Errorf(ctx, "`%s`: swap-size is illegal, %v", sizeStr, err)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "swap-size", sizeStr, err)
+		}
+		return se.fill(ctx, size)
+	case SwapDisable:
+		var devices []string
+		if devicesStr := model.ActionFlags["swap-devices"]; devicesStr != "" {
+			devices = util.RemoveDuplicates(strings.Split(devicesStr, ","))
+		}
+		return se.disable(ctx, uid, devices)
+	default:
+		log.Errorf(ctx, "`%s`: swap-mode must be fill or disable", swapMode)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "swap-mode", swapMode, "it must be fill or disable")
+	}
+}
+
+// fill allocates and touches the memory chunk by chunk, the idle chunks allocated earlier are
+// swapped out by the kernel. It stops allocating when the swapped memory of the process reaches
+// the size, or the allocated memory reaches the available memory plus the size.
+func (se *memSwapExecutor) fill(ctx context.Context, size int) *spec.Response {
+	swap, err := mem.SwapMemory()
+	if err != nil {
+		log.Errorf(ctx, "get swap memory failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get swap memory", err)
+	}
+	if swap.Free < uint64(size)<<20 {
+		log.Errorf(ctx, "only %dMB swap is free, %dMB is expected", swap.Free>>20, size)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "swap-size", strconv.Itoa(size)+"M", fmt.Sprintf("only %dMB swap is free", swap.Free>>20))
+	}
+	if _, err := swappedMemory(); err != nil {
+		log.Errorf(ctx, "get the swapped memory failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get the swapped memory", err)
+	}
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		log.Errorf(ctx, "get virtual memory failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get virtual memory", err)
+	}
+	limit := int64(vm.Available>>20) + int64(size)
+	log.Infof(ctx, "push %dMB into swap, available: %dMB, swap free: %dMB", size, vm.Available>>20, swap.Free>>20)
+
+	var cache [][]Block
+	var filled int64
+	for filled < limit {
+		swapped, err := swappedMemory()
+		if err != nil {
+			log.Warnf(ctx, "get the swapped memory failed, %v", err)
+		} else if swapped >= uint64(size)<<20 {
+			break
+		}
+		cache = append(cache, touchBlocks(make([]Block, 8*swapChunk)))
+		filled += swapChunk
+	}
+	reportSwappedMemory(ctx, filled)
+	for range time.Tick(memReportInterval) {
+		// the idle chunks are kept referenced until the process is killed
+		log.Debugf(ctx, "%d chunks allocated", len(cache))
+		reportSwappedMemory(ctx, filled)
+	}
+	return spec.Success()
+}
+
+func reportSwappedMemory(ctx context.Context, filled int64) {
+	swapped, err := swappedMemory()
+	if err != nil {
+		log.Warnf(ctx, "get the swapped memory failed, %v", err)
+		return
+	}
+	log.Infof(ctx, "mem allocated: %dMB, swapped: %dMB", filled, swapped>>20)
+}
+
+// disable runs swapoff on the swap areas and holds until the experiment is destroyed
+func (se *memSwapExecutor) disable(ctx context.Context, uid string, devices []string) *spec.Response {
+	response := se.channel.Run(ctx, "cat", "/proc/swaps")
+	if !response.Success {
+		log.Errorf(ctx, "read /proc/swaps failed, %s", response.Err)
+		return response
+	}
+	areas, err := selectSwapAreas(parseSwaps(response.Result.(string)), devices)
+	if err != nil {
+		log.Errorf(ctx, "`%s`: swap-devices is illegal, %v", strings.Join(devices, ","), err)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "swap-devices", strings.Join(devices, ","), err)
+	}
+	if len(areas) == 0 {
+		log.Errorf(ctx, "no active swap area")
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "swapoff", "no active swap area")
+	}
+	record := &swapRecord{Areas: areas}
+	if err := exec.SaveRecord(swapAction, uid, record); err != nil {
+		log.Errorf(ctx, "save the swap areas failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the swap areas", err)
+	}
+	for i, area := range areas {
+		if response := se.channel.Run(ctx, "swapoff", shellQuote(area.Name)); !response.Success {
+			log.Errorf(ctx, "swapoff %s failed, %s", area.Name, response.Err)
+			se.restore(ctx, areas[:i])
+			exec.RemoveRecord(swapAction, uid)
+			return response
+		}
+		log.Infof(ctx, "swapoff %s, priority: %d", area.Name, area.Priority)
+	}
+	select {}
+}
+
+func (se *memSwapExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record swapRecord
+	// only the disable mode records the swap areas
+	err := exec.LoadRecord(swapAction, uid, &record)
+	switch {
+	case err == nil:
+		if failed := se.restore(ctx, record.Areas); len(failed) > 0 {
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "swapon "+strings.Join(failed, ","), "see the log for details")
+		}
+		if err := exec.RemoveRecord(swapAction, uid); err != nil {
+			log.Warnf(ctx, "remove the swap areas record failed, %v", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		log.Errorf(ctx, "load the swap areas failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the swap areas", err)
+	}
+	return exec.Destroy(ctx, se.channel, "mem swap")
+}
+
+// restore runs swapon on the swap areas with the original priorities, returns the areas failed
+func (se *memSwapExecutor) restore(ctx context.Context, areas []swapArea) []string {
+	var failed []string
+	for _, area := range areas {
+		args := shellQuote(area.Name)
+		// the negative priorities are assigned by the kernel, swapon only accepts 0-32767
+		if area.Priority >= 0 {
+			args = fmt.Sprintf("-p %d %s", area.Priority, args)
+		}
+		if response := se.channel.Run(ctx, "swapon", args); !response.Success {
+			log.Errorf(ctx, "swapon %s failed, %s", area.Name, response.Err)
+			failed = append(failed, area.Name)
+			continue
+		}
+		log.Infof(ctx, "swapon %s, priority: %d", area.Name, area.Priority)
+	}
+	return failed
+}
+
+// shellQuote quotes the argument in single quotes for the shell of the channel, so that
+// the spaces and the metacharacters in the swap file name are kept
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// parseSwaps parses the content of /proc/swaps, the first line is the header
+func parseSwaps(content string) []swapArea {
+	var areas []swapArea
+	for i, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 5 {
+			continue
+		}
+		priority, err := strconv.Atoi(fields[4])
+		if err != nil {
+			continue
+		}
+		// the spaces in the file name are escaped
+		name := strings.ReplaceAll(fields[0], `\040`, " ")
+		areas = append(areas, swapArea{Name: name, Priority: priority})
+	}
+	return areas
+}
+
+// selectSwapAreas returns the areas of the devices, all areas are returned if devices is empty
+func selectSwapAreas(areas []swapArea, devices []string) ([]swapArea, error) {
+	if len(devices) == 0 {
+		return areas, nil
+	}
+	var selected []swapArea
+	for _, device := range devices {
+		found := false
+		for _, area := range areas {
+			if area.Name == device {
+				selected, found = append(selected, area), true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not an active swap area", device)
+		}
+	}
+	return selected, nil
+}
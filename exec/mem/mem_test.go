@@ -0,0 +1,550 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/channel"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+)
+
+func TestParseMemSize(t *testing.T) {
+	tests := []struct {
+		size     string
+		expected int
+	}{
+		{"512", 512},
+		{"512M", 512},
+		{"512mb", 512},
+		{"2G", 2048},
+		{"2GB", 2048},
+		{"0", 0},
+		{"-1G", 0},
+		{"1.5G", 0},
+		{"2T", 0},
+	}
+	for _, tt := range tests {
+		size, err := parseMemSize(tt.size)
+		if tt.expected == 0 {
+			if err == nil {
+				t.Errorf("%s: expected error, got %d", tt.size, size)
+			}
+			continue
+		}
+		if err != nil || size != tt.expected {
+			t.Errorf("%s: expected %d, got %d, %v", tt.size, tt.expected, size, err)
+		}
+	}
+}
+
+func TestMemLeakTarget(t *testing.T) {
+	linear := &memLeak{growth: GrowthLinear, cap: 1000, duration: 100 * time.Second}
+	exponential := &memLeak{growth: GrowthExponential, cap: 1024, duration: 100 * time.Second}
+	tests := []struct {
+		leak     *memLeak
+		elapsed  time.Duration
+		expected int64
+	}{
+		{linear, 0, 0},
+		{linear, 25 * time.Second, 250},
+		{linear, 100 * time.Second, 1000},
+		{linear, 200 * time.Second, 1000},
+		{exponential, 0, 1},
+		{exponential, 50 * time.Second, 32},
+		{exponential, 90 * time.Second, 512},
+		{exponential, 150 * time.Second, 1024},
+	}
+	for _, tt := range tests {
+		if target := tt.leak.target(tt.elapsed); target != tt.expected {
+			t.Errorf("%s after %s: expected %d, got %d", tt.leak.growth, tt.elapsed, tt.expected, target)
+		}
+	}
+}
+
+func TestLeakCgroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cgroup is only supported on linux")
+	}
+	// the test process is the target, its cgroup path is mapped into a fake v2 tree
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		t.Skip(err)
+	}
+	var path string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = strings.TrimPrefix(line, "0::")
+		}
+	}
+	if path == "" {
+		t.Skip("the unified hierarchy is not found")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, path)
+	files := map[string]string{
+		"cgroup.procs":   "",
+		"memory.current": "104857600\n",
+		"memory.max":     "209715200\n",
+		"memory.high":    "max\n",
+		"memory.stat":    "anon 104857600\nfile 0\n",
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("memory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	ctx := context.Background()
+	model := &spec.ExpModel{ActionFlags: map[string]string{
+		"cgroup-root":            root,
+		channel.NSTargetFlagName: pid,
+	}}
+	cg, resp := leakCgroup(ctx, model, false)
+	if resp != nil || cg != nil {
+		t.Fatalf("leakCgroup() without threshold = %v, %v", cg, resp)
+	}
+	if procs, _ := os.ReadFile(filepath.Join(dir, "cgroup.procs")); len(procs) != 0 {
+		t.Fatalf("the leak process joined the cgroup without threshold or join-cgroup")
+	}
+
+	// the leaked memory is charged to the target cgroup whose usage is checked
+	cg, resp = leakCgroup(ctx, model, true)
+	if resp != nil {
+		t.Fatalf("leakCgroup() failed, %v", resp)
+	}
+	if procs, _ := os.ReadFile(filepath.Join(dir, "cgroup.procs")); string(procs) != pid {
+		t.Fatalf("cgroup.procs = %q, expected %q", procs, pid)
+	}
+	if reached, err := thresholdReached(cg, 50); err != nil || !reached {
+		t.Fatalf("thresholdReached(50) = %v, %v, expected true", reached, err)
+	}
+	if reached, err := thresholdReached(cg, 60); err != nil || reached {
+		t.Fatalf("thresholdReached(60) = %v, %v, expected false", reached, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	model.ActionFlags["join-cgroup"] = spec.True
+	if _, resp = leakCgroup(ctx, model, false); resp != nil {
+		t.Fatalf("leakCgroup() with join-cgroup failed, %v", resp)
+	}
+	if procs, _ := os.ReadFile(filepath.Join(dir, "cgroup.procs")); string(procs) != pid {
+		t.Fatalf("cgroup.procs = %q with join-cgroup, expected %q", procs, pid)
+	}
+
+	delete(model.ActionFlags, channel.NSTargetFlagName)
+	if _, resp = leakCgroup(ctx, model, false); resp == nil || resp.Code != spec.ParameterLess.Code {
+		t.Fatalf("leakCgroup() with join-cgroup and without target = %v, expected ParameterLess", resp)
+	}
+}
+
+func TestMemLockerLimit(t *testing.T) {
+	ctx := context.Background()
+	l := &memLocker{limit: 3 * uint64(blockSize)}
+	l.lock(ctx, make([]Block, 2))
+	if l.full {
+		t.Skip("mlock is not allowed by the RLIMIT_MEMLOCK of the test process")
+	}
+	if l.locked != 2*uint64(blockSize) {
+		t.Fatalf("expected %d bytes locked, got %d", 2*blockSize, l.locked)
+	}
+	// the buffer beyond the limit is not locked, and nothing is locked later
+	l.lock(ctx, make([]Block, 2))
+	if !l.full || l.locked != 2*uint64(blockSize) {
+		t.Errorf("expected the limit reached with %d bytes locked, got %v, %d", 2*blockSize, l.full, l.locked)
+	}
+	l.lock(ctx, make([]Block, 1))
+	if l.locked != 2*uint64(blockSize) {
+		t.Errorf("expected nothing locked after the limit, got %d", l.locked)
+	}
+
+	// root may lock beyond the limit
+	if os.Geteuid() == 0 {
+		l = &memLocker{limit: uint64(blockSize), privileged: true}
+		l.lock(ctx, make([]Block, 2))
+		if l.full || l.locked != 2*uint64(blockSize) {
+			t.Errorf("expected %d bytes locked by root, got %v, %d", 2*blockSize, l.full, l.locked)
+		}
+	}
+}
+
+func TestHugepageExhaustSize(t *testing.T) {
+	tests := []struct {
+		count       int
+		free        uint64
+		pageSize    uint64
+		expected, n int
+		ok          bool
+	}{
+		{0, 10, 2 << 20, 10, 160, true},
+		{4, 10, 2 << 20, 4, 64, true},
+		{20, 10, 2 << 20, 10, 160, true},
+		{1, 1, 1 << 30, 1, 8192, true},
+		{0, 0, 2 << 20, 0, 0, false},
+		{1, 4, 64 << 10, 0, 0, false},
+		{2, 4, 64 << 10, 2, 1, true},
+	}
+	for _, tt := range tests {
+		count, n, err := hugepageExhaustSize(tt.count, tt.free, tt.pageSize)
+		if (err == nil) != tt.ok || count != tt.expected || n != tt.n {
+			t.Errorf("hugepageExhaustSize(%d, %d, %d) = %d, %d, %v, expected %d, %d, %v",
+				tt.count, tt.free, tt.pageSize, count, n, err, tt.expected, tt.n, tt.ok)
+		}
+	}
+}
+
+func TestRoundHugepages(t *testing.T) {
+	tests := []struct {
+		fill, expect int64
+		pageSize     uint64
+		expected     int64
+	}{
+		{5, 100, 2 << 20, 4},
+		{1, 100, 2 << 20, 2},
+		{1, 1, 2 << 20, 0},
+		{100, 2048, 1 << 30, 1024},
+		{7, 100, 64 << 10, 7},
+	}
+	for _, tt := range tests {
+		if fill := roundHugepages(tt.fill, tt.expect, tt.pageSize); fill != tt.expected {
+			t.Errorf("roundHugepages(%d, %d, %d) = %d, expected %d", tt.fill, tt.expect, tt.pageSize, fill, tt.expected)
+		}
+	}
+}
+
+func TestLastLevelCacheSize(t *testing.T) {
+	dir := t.TempDir()
+	for index, cache := range [][2]string{{"1", "32K"}, {"2", "1024K"}, {"3", "32M"}} {
+		p := filepath.Join(dir, fmt.Sprintf("index%d", index))
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, "level"), []byte(cache[0]+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, "size"), []byte(cache[1]+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, err := lastLevelCacheSize(dir)
+	if err != nil || size != 32<<20 {
+		t.Errorf("expected 32M, got %d, %v", size, err)
+	}
+	if _, err := lastLevelCacheSize(filepath.Join(dir, "none")); err == nil {
+		t.Errorf("expected error without cache")
+	}
+}
+
+func TestParseSwaps(t *testing.T) {
+	content := "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n" +
+		"/dev/sda2                               partition\t4194300\t\t0\t\t-2\n" +
+		"/swap\\040file                           file\t\t1048572\t\t512\t\t10\n"
+	areas := parseSwaps(content)
+	expected := []swapArea{{Name: "/dev/sda2", Priority: -2}, {Name: "/swap file", Priority: 10}}
+	if !reflect.DeepEqual(areas, expected) {
+		t.Fatalf("expected %v, got %v", expected, areas)
+	}
+	if selected, err := selectSwapAreas(areas, []string{"/swap file"}); err != nil || !reflect.DeepEqual(selected, expected[1:]) {
+		t.Errorf("expected %v, got %v, %v", expected[1:], selected, err)
+	}
+	if _, err := selectSwapAreas(areas, []string{"/dev/sdb1"}); err == nil {
+		t.Errorf("expected error for an inactive swap area")
+	}
+}
+
+func TestEvictPath(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("posix_fadvise is only supported on linux")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a": "abc", "sub/b": "12345"} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, bytes, err := evictPath(context.Background(), dir)
+	if err != nil || files != 2 || bytes != 8 {
+		t.Errorf("expected 2 files of 8 bytes, got %d files of %d bytes, %v", files, bytes, err)
+	}
+	if _, _, err := evictPath(context.Background(), filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for a missing path")
+	}
+}
+
+func TestParseNodeMeminfo(t *testing.T) {
+	content := "Node 1 MemTotal:        6158152 kB\n" +
+		"Node 1 MemFree:         5279720 kB\n" +
+		"Node 1 FilePages:        538256 kB\n" +
+		"Node 1 HugePages_Total:     16\n" +
+		"Node 1 HugePages_Free:      12\n"
+	meminfo := parseNodeMeminfo(content)
+	expected := map[string]uint64{
+		"MemTotal":        6158152 * 1024,
+		"MemFree":         5279720 * 1024,
+		"FilePages":       538256 * 1024,
+		"HugePages_Total": 16,
+		"HugePages_Free":  12,
+	}
+	if !reflect.DeepEqual(meminfo, expected) {
+		t.Errorf("expected %v, got %v", expected, meminfo)
+	}
+}
+
+// fakeCgroup returns the cgroup v2 of the files under a temporary root
+func fakeCgroup(t *testing.T, files map[string]string) (*exec.Cgroup, string) {
+	root := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cg := &exec.Cgroup{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{"version":2,"root":%q,"paths":{"":"/"}}`, root)), cg); err != nil {
+		t.Fatal(err)
+	}
+	recordDir := exec.RecordDir
+	exec.RecordDir = filepath.Join(t.TempDir(), "records")
+	t.Cleanup(func() { exec.RecordDir = recordDir })
+	return cg, root
+}
+
+func TestMemLimit(t *testing.T) {
+	cg, root := fakeCgroup(t, map[string]string{
+		"memory.current": "104857600\n",
+		"memory.max":     "max\n",
+		"memory.high":    "max\n",
+		"memory.events":  "oom 0\noom_kill 1\n",
+		"memory.stat":    "anon 104857600\nfile 0\n",
+	})
+	ctx := context.Background()
+	le := &memLimitExecutor{}
+	if resp := le.start(ctx, "uid1", cg, LimitMax, 0, 50); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	if value, _ := cg.ReadFile("", "memory.max"); value != "52428800" {
+		t.Errorf("expected memory.max 52428800, got %s", value)
+	}
+	if err := os.WriteFile(filepath.Join(root, "memory.events"), []byte("oom 2\noom_kill 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resp := le.stop(ctx, "uid1")
+	if !resp.Success || resp.Result.(LimitResult).OomKills != 2 {
+		t.Errorf("expected 2 oom kills, got %+v", resp)
+	}
+	if value, _ := cg.ReadFile("", "memory.max"); value != "max" {
+		t.Errorf("expected memory.max restored to max, got %s", value)
+	}
+
+	// the limit near 0 is rejected
+	if resp := le.start(ctx, "uid2", cg, LimitMax, 0, 1); resp.Success {
+		t.Errorf("expected the limit of 1%% of 100MB rejected")
+	}
+
+	// the finite limit is never loosened
+	if err := os.WriteFile(filepath.Join(root, "memory.max"), []byte("134217728\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{128, 256} {
+		if resp := le.start(ctx, "uid4", cg, LimitMax, size, 0); resp.Success || resp.Code != spec.ParameterIllegal.Code {
+			t.Errorf("expected the limit of %dMB above 128MB rejected, got %+v", size, resp)
+		}
+	}
+	if value, _ := cg.ReadFile("", "memory.max"); value != "134217728" {
+		t.Errorf("expected memory.max unchanged, got %s", value)
+	}
+	if resp := le.start(ctx, "uid4", cg, LimitMax, 64, 0); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	if resp := le.stop(ctx, "uid4"); !resp.Success {
+		t.Fatalf("stop failed, %s", resp.Err)
+	}
+	if value, _ := cg.ReadFile("", "memory.max"); value != "134217728" {
+		t.Errorf("expected memory.max restored to 134217728, got %s", value)
+	}
+
+	// the cgroup removed by the oom is treated as restored
+	if resp := le.start(ctx, "uid3", cg, LimitHigh, 64, 0); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if resp := le.stop(ctx, "uid3"); !resp.Success {
+		t.Errorf("expected the removed cgroup treated as restored, got %s", resp.Err)
+	}
+	if resp := le.stop(ctx, "uid3"); resp.Success {
+		t.Errorf("expected the record removed")
+	}
+}
+
+func TestAlignBufferSize(t *testing.T) {
+	for _, tt := range [][2]int{{0, 1 << 20}, {64 << 10, 1 << 20}, {1 << 20, 1 << 20}, {(2 << 20) + 1, 3 << 20}} {
+		if size := alignBufferSize(tt[0]); size != tt[1] || size%blockSize != 0 {
+			t.Errorf("%d: expected %d, got %d", tt[0], tt[1], size)
+		}
+	}
+	// the chunks stay aligned when the offset wraps
+	size := alignBufferSize(2 * bandwidthChunk)
+	for _, kernel := range []string{KernelCopy, KernelRead, KernelWrite} {
+		var streamed uint64
+		src := asBytes(make([]Block, size/blockSize))
+		dst := asBytes(make([]Block, size/blockSize))
+		stream(kernel, src, dst, 0, 3, &streamed)
+		expected := uint64(3 * size)
+		if kernel == KernelCopy {
+			expected *= 2
+		}
+		if streamed != expected {
+			t.Errorf("%s: expected %d bytes streamed, got %d", kernel, expected, streamed)
+		}
+		if kernel != KernelWrite {
+			continue
+		}
+		// the last pass writes the sink 4 to the first chunk and 5 to the second one
+		words := unsafe.Slice((*uint64)(unsafe.Pointer(&src[0])), len(src)/8)
+		for i, w := range words {
+			if chunk := uint64(4 + i*8/bandwidthChunk); w != chunk {
+				t.Fatalf("%s: word %d of the chunk %d is %d, expected %d", kernel, i, i*8/bandwidthChunk, w, chunk)
+			}
+		}
+	}
+}
+
+// fakeChannel records the commands run through the channel, the scripts in fail fail
+type fakeChannel struct {
+	spec.Channel
+	commands []string
+	fail     map[string]bool
+}
+
+func (c *fakeChannel) Run(ctx context.Context, script, args string) *spec.Response {
+	c.commands = append(c.commands, strings.TrimSpace(script+" "+args))
+	if c.fail[script] {
+		return spec.ReturnFail(spec.OsCmdExecFailed, script+" failed")
+	}
+	return spec.ReturnSuccess("")
+}
+
+func TestCacheTmpfs(t *testing.T) {
+	recordDir := exec.RecordDir
+	exec.RecordDir = filepath.Join(t.TempDir(), "records")
+	defer func() { exec.RecordDir = recordDir }()
+	ctx := context.Background()
+
+	cl := &fakeChannel{}
+	dir, err := mountCacheTmpfs(ctx, cl, "uid1", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"mkdir -p " + dir, "mount -t tmpfs -o size=64m tmpfs " + dir}
+	if !reflect.DeepEqual(cl.commands, expected) {
+		t.Errorf("expected %q, got %q", expected, cl.commands)
+	}
+	cl.commands = nil
+	if err := umountCacheTmpfs(ctx, cl, "uid1"); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"umount " + dir, "rmdir " + dir}
+	if !reflect.DeepEqual(cl.commands, expected) {
+		t.Errorf("expected %q, got %q", expected, cl.commands)
+	}
+	// the record is removed, the experiment is not in the cache mode any more
+	cl.commands = nil
+	if err := umountCacheTmpfs(ctx, cl, "uid1"); err != nil || len(cl.commands) != 0 {
+		t.Errorf("expected nothing to umount, got %q, %v", cl.commands, err)
+	}
+
+	// the failed mount is not recorded
+	cl = &fakeChannel{fail: map[string]bool{"mount": true}}
+	if _, err := mountCacheTmpfs(ctx, cl, "uid2", 64); err == nil {
+		t.Fatalf("expected mount error")
+	}
+	if err := umountCacheTmpfs(ctx, cl, "uid2"); err != nil {
+		t.Errorf("expected no record for the failed mount, got %v", err)
+	}
+
+	// the record is kept for the next destroy if the umount fails
+	cl = &fakeChannel{fail: map[string]bool{"umount": true}}
+	if _, err := mountCacheTmpfs(ctx, cl, "uid3", 64); err != nil {
+		t.Fatal(err)
+	}
+	if err := umountCacheTmpfs(ctx, cl, "uid3"); err == nil {
+		t.Fatalf("expected umount error")
+	}
+	cl.fail = nil
+	if err := umountCacheTmpfs(ctx, cl, "uid3"); err != nil {
+		t.Errorf("expected the tmpfs umounted by the retried destroy, got %v", err)
+	}
+
+	// the corrupt record is an error rather than not in the cache mode
+	if err := exec.SaveRecord(cacheAction, "uid4", "corrupt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := umountCacheTmpfs(ctx, cl, "uid4"); err == nil {
+		t.Errorf("expected error for the corrupt record")
+	}
+}
+
+func TestSwapRestore(t *testing.T) {
+	cl := &fakeChannel{fail: map[string]bool{}}
+	se := &memSwapExecutor{channel: cl}
+	areas := []swapArea{{Name: "/swap file", Priority: 5}, {Name: "/dev/sda2", Priority: -2}, {Name: "/swap'$(id)", Priority: 0}}
+	if failed := se.restore(context.Background(), areas); len(failed) != 0 {
+		t.Fatalf("expected no failure, got %v", failed)
+	}
+	expected := []string{`swapon -p 5 '/swap file'`, `swapon '/dev/sda2'`, `swapon -p 0 '/swap'\''$(id)'`}
+	if !reflect.DeepEqual(cl.commands, expected) {
+		t.Errorf("expected %q, got %q", expected, cl.commands)
+	}
+
+	// the quoted name is kept by the shell
+	if out, err := osexec.Command("sh", "-c", "printf %s "+shellQuote(areas[2].Name)).Output(); err != nil || string(out) != areas[2].Name {
+		t.Errorf("expected %q, got %q, %v", areas[2].Name, out, err)
+	}
+
+	cl.commands, cl.fail["swapon"] = nil, true
+	if failed := se.restore(context.Background(), areas[:2]); !reflect.DeepEqual(failed, []string{"/swap file", "/dev/sda2"}) {
+		t.Errorf("expected all areas failed, got %v", failed)
+	}
+}
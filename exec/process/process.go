@@ -45,6 +45,8 @@ func NewProcessCommandModelSpec() spec.ExpModelCommandSpec {
 				NewKillProcessActionCommandSpec(),
 				NewStopProcessActionCommandSpec(),
 				NewProcessLoadActionCommandSpec(),
+				NewPriorityActionCommandSpec(),
+				NewOomPriorityActionCommandSpec(),
 			},
 		},
 	}
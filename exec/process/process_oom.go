@@ -0,0 +1,255 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+)
+
+const oomPriorityAction = "process-oom-priority"
+
+type OomPriorityActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewOomPriorityActionCommandSpec() spec.ExpActionCommandSpec {
+	return &OomPriorityActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "process",
+					Desc: "Process name",
+				},
+				&spec.ExpFlag{
+					Name: "process-cmd",
+					Desc: "Process name in command",
+				},
+				&spec.ExpFlag{
+					Name: "count",
+					Desc: "Limit count, 0 means unlimited",
+				},
+				&spec.ExpFlag{
+					Name: "local-port",
+					Desc: "Local service ports. Separate multiple ports with commas (,) or connector representing ranges, for example: 80,8000-8080",
+				},
+				&spec.ExpFlag{
+					Name: "exclude-process",
+					Desc: "Exclude process",
+				},
+				&spec.ExpFlag{
+					Name: "pid",
+					Desc: "pid",
+				},
+			},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name:     "score-adj",
+					Desc:     "The oom_score_adj (-1000-1000) of the process, 1000 makes the process the first victim of the oom killer, -1000 disables the oom kill of the process",
+					Required: true,
+				},
+			},
+			ActionExecutor: &OomPriorityExecutor{},
+			ActionExample: `
+# Make the process that contains the "SimpleHTTPServer" keyword the first victim of the oom killer
+blade create process oom-priority --process SimpleHTTPServer --score-adj 1000
+
+# Protect the process listening on port 3306 from the oom killer
+blade create process oom-priority --local-port 3306 --score-adj -1000`,
+			ActionCategories: []string{category.SystemProcess},
+		},
+	}
+}
+
+func (*OomPriorityActionCommandSpec) Name() string {
+	return "oom-priority"
+}
+
+func (*OomPriorityActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*OomPriorityActionCommandSpec) ShortDesc() string {
+	return "process oom victim steering"
+}
+
+func (o *OomPriorityActionCommandSpec) LongDesc() string {
+	if o.ActionLongDesc != "" {
+		return o.ActionLongDesc
+	}
+	return "Set the oom_score_adj of the process to decide which process the oom killer picks under memory pressure, " +
+		"the original values are restored when the experiment is destroyed"
+}
+
+type OomPriorityExecutor struct {
+	channel spec.Channel
+}
+
+func (oe *OomPriorityExecutor) Name() string {
+	return "oom-priority"
+}
+
+func (oe *OomPriorityExecutor) SetChannel(channel spec.Channel) {
+	oe.channel = channel
+}
+
+// oomState is the original oom_score_adj of the process
+type oomState struct {
+	Pid       string `json:"pid"`
+	StartTime uint64 `json:"startTime"`
+	ScoreAdj  int    `json:"scoreAdj"`
+}
+
+type oomRecord struct {
+	States []oomState `json:"states"`
+}
+
+func (oe *OomPriorityExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if oe.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return oe.stop(ctx, uid)
+	}
+
+	scoreAdjStr := model.ActionFlags["score-adj"]
+	if scoreAdjStr == "" {
+		log.Errorf(ctx, "score-adj is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "score-adj")
+	}
+	scoreAdj, err := strconv.Atoi(scoreAdjStr)
+	if err != nil || scoreAdj < -1000 || scoreAdj > 1000 {
+		log.Errorf(ctx, "`%s`: score-adj is illegal, it must be an integer between -1000 and 1000", scoreAdjStr)
+		return spec.ResponseFailWithFlags(spec.ParameterIllegal, "score-adj", scoreAdjStr, "it must be an integer between -1000 and 1000")
+	}
+	if response, ok := oe.channel.IsAllCommandsAvailable(ctx, []string{"cat", "echo", "test"}); !ok {
+		return response
+	}
+
+	resp := getPids(ctx, oe.channel, model, uid)
+	if !resp.Success {
+		return resp
+	}
+	pids, ok := resp.Result.(string)
+	if !ok || pids == "" {
+		// the process is not found and ignore-not-found is specified
+		return resp
+	}
+	return oe.start(ctx, uid, strings.Fields(pids), scoreAdj)
+}
+
+func (oe *OomPriorityExecutor) start(ctx context.Context, uid string, pids []string, scoreAdj int) *spec.Response {
+	record := &oomRecord{}
+	for _, pid := range pids {
+		startTime, alive, err := readStartTime(ctx, oe.channel, pid)
+		if err == nil && !alive {
+			err = fmt.Errorf("the process has exited")
+		}
+		if err != nil {
+			log.Errorf(ctx, "get the start time of pid %s failed, %v", pid, err)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get the start time of pid "+pid, err)
+		}
+		original, err := oe.readProcInt(ctx, pid, "oom_score_adj")
+		if err != nil {
+			log.Errorf(ctx, "get the oom_score_adj of pid %s failed, %v", pid, err)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get the oom_score_adj of pid "+pid, err)
+		}
+		record.States = append(record.States, oomState{Pid: pid, StartTime: startTime, ScoreAdj: original})
+	}
+	if err := exec.SaveRecord(oomPriorityAction, uid, record); err != nil {
+		log.Errorf(ctx, "save the original oom_score_adj failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the original oom_score_adj", err)
+	}
+
+	for i, state := range record.States {
+		if err := oe.writeScoreAdj(ctx, state.Pid, scoreAdj); err != nil {
+			log.Errorf(ctx, "change the oom_score_adj of pid %s failed, %v", state.Pid, err)
+			oe.restore(ctx, record.States[:i+1])
+			exec.RemoveRecord(oomPriorityAction, uid)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "change the oom_score_adj of pid "+state.Pid, err)
+		}
+		// the oom_score is the badness the oom killer compares, it is logged to verify the victim
+		score, _ := oe.readProcInt(ctx, state.Pid, "oom_score")
+		log.Infof(ctx, "change the oom_score_adj of pid %s, %d -> %d, oom_score: %d", state.Pid, state.ScoreAdj, scoreAdj, score)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func (oe *OomPriorityExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record oomRecord
+	if err := exec.LoadRecord(oomPriorityAction, uid, &record); err != nil {
+		log.Errorf(ctx, "load the original oom_score_adj failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the original oom_score_adj", err)
+	}
+	if failed := oe.restore(ctx, record.States); len(failed) > 0 {
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "restore the oom_score_adj of pid "+strings.Join(failed, ","), "see the log for details")
+	}
+	if err := exec.RemoveRecord(oomPriorityAction, uid); err != nil {
+		log.Warnf(ctx, "remove the original oom_score_adj record failed, %v", err)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+// restore restores the original oom_score_adj of the processes, returns the pids failed.
+// The processes exited, such as the oom victims, are treated as restored.
+func (oe *OomPriorityExecutor) restore(ctx context.Context, states []oomState) []string {
+	var failed []string
+	for _, state := range states {
+		if alive, err := sameProcess(ctx, oe.channel, state.Pid, state.StartTime); err != nil {
+			log.Errorf(ctx, "check the pid %s failed, %v", state.Pid, err)
+			failed = append(failed, state.Pid)
+			continue
+		} else if !alive {
+			log.Infof(ctx, "the pid %s has exited, skip restoring its oom_score_adj", state.Pid)
+			continue
+		}
+		if err := oe.writeScoreAdj(ctx, state.Pid, state.ScoreAdj); err != nil {
+			if alive, _ := sameProcess(ctx, oe.channel, state.Pid, state.StartTime); !alive {
+				log.Infof(ctx, "the pid %s has exited, skip restoring its oom_score_adj", state.Pid)
+				continue
+			}
+			log.Errorf(ctx, "restore the oom_score_adj of pid %s failed, %v", state.Pid, err)
+			failed = append(failed, state.Pid)
+			continue
+		}
+		log.Infof(ctx, "restore the oom_score_adj of pid %s to %d", state.Pid, state.ScoreAdj)
+	}
+	return failed
+}
+
+func (oe *OomPriorityExecutor) readProcInt(ctx context.Context, pid, name string) (int, error) {
+	response := oe.channel.Run(ctx, "cat", fmt.Sprintf("/proc/%s/%s", pid, name))
+	if !response.Success {
+		return 0, fmt.Errorf("read %s failed, %s", name, response.Err)
+	}
+	return strconv.Atoi(strings.TrimSpace(response.Result.(string)))
+}
+
+func (oe *OomPriorityExecutor) writeScoreAdj(ctx context.Context, pid string, scoreAdj int) error {
+	response := oe.channel.Run(ctx, "echo", fmt.Sprintf("%d > /proc/%s/oom_score_adj", scoreAdj, pid))
+	if !response.Success {
+		return fmt.Errorf("write oom_score_adj failed, %s", response.Err)
+	}
+	return nil
+}
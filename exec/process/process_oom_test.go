@@ -0,0 +1,120 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+)
+
+type fakeProc struct {
+	startTime uint64
+	scoreAdj  int
+}
+
+// fakeProcChannel serves the /proc files of the processes in procs
+type fakeProcChannel struct {
+	spec.Channel
+	procs map[string]*fakeProc
+}
+
+func (c *fakeProcChannel) Run(ctx context.Context, script, args string) *spec.Response {
+	fields := strings.Fields(args)
+	path := strings.Split(strings.TrimPrefix(fields[len(fields)-1], "/proc/"), "/")
+	proc, ok := c.procs[path[0]]
+	if !ok {
+		return spec.ReturnFail(spec.OsCmdExecFailed, "no such process")
+	}
+	switch {
+	case script == "test":
+		return spec.ReturnSuccess("")
+	case script == "cat" && path[1] == "stat":
+		return spec.ReturnSuccess(fmt.Sprintf("%s (proc) S 1 1 1 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 %d 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0", path[0], proc.startTime))
+	case script == "cat" && path[1] == "oom_score_adj":
+		return spec.ReturnSuccess(fmt.Sprintf("%d\n", proc.scoreAdj))
+	case script == "cat" && path[1] == "oom_score":
+		return spec.ReturnSuccess("666\n")
+	case script == "echo" && path[1] == "oom_score_adj":
+		scoreAdj, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return spec.ReturnFail(spec.OsCmdExecFailed, err.Error())
+		}
+		proc.scoreAdj = scoreAdj
+		return spec.ReturnSuccess("")
+	}
+	return spec.ReturnFail(spec.OsCmdExecFailed, "unexpected command "+script+" "+args)
+}
+
+func TestOomPriority(t *testing.T) {
+	recordDir := exec.RecordDir
+	exec.RecordDir = filepath.Join(t.TempDir(), "records")
+	defer func() { exec.RecordDir = recordDir }()
+	ctx := context.Background()
+
+	cl := &fakeProcChannel{procs: map[string]*fakeProc{
+		"100": {startTime: 1000, scoreAdj: 0},
+		"200": {startTime: 2000, scoreAdj: -500},
+		"300": {startTime: 3000, scoreAdj: 200},
+	}}
+	oe := &OomPriorityExecutor{channel: cl}
+	if resp := oe.start(ctx, "uid1", []string{"100", "200", "300"}, 1000); !resp.Success {
+		t.Fatalf("start failed, %s", resp.Err)
+	}
+	for pid, proc := range cl.procs {
+		if proc.scoreAdj != 1000 {
+			t.Errorf("expected oom_score_adj 1000 of pid %s, got %d", pid, proc.scoreAdj)
+		}
+	}
+	var record oomRecord
+	if err := exec.LoadRecord(oomPriorityAction, "uid1", &record); err != nil {
+		t.Fatal(err)
+	}
+	if len(record.States) != 3 || record.States[1] != (oomState{Pid: "200", StartTime: 2000, ScoreAdj: -500}) {
+		t.Errorf("unexpected record: %+v", record.States)
+	}
+
+	// the pid 200 is the oom victim and the pid 300 is recycled by another process
+	delete(cl.procs, "200")
+	cl.procs["300"] = &fakeProc{startTime: 3500, scoreAdj: 1000}
+	if resp := oe.stop(ctx, "uid1"); !resp.Success {
+		t.Fatalf("stop failed, %s", resp.Err)
+	}
+	if adj := cl.procs["100"].scoreAdj; adj != 0 {
+		t.Errorf("expected oom_score_adj 0 restored, got %d", adj)
+	}
+	if adj := cl.procs["300"].scoreAdj; adj != 1000 {
+		t.Errorf("expected the recycled pid untouched, got %d", adj)
+	}
+	if err := exec.LoadRecord(oomPriorityAction, "uid1", &record); err == nil {
+		t.Errorf("expected the record removed")
+	}
+
+	// nothing is changed if a process exits before the start
+	if resp := oe.start(ctx, "uid2", []string{"100", "400"}, -1000); resp.Success {
+		t.Fatalf("expected start error for the exited pid")
+	}
+	if adj := cl.procs["100"].scoreAdj; adj != 0 {
+		t.Errorf("expected oom_score_adj 0 untouched, got %d", adj)
+	}
+}
@@ -0,0 +1,454 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec"
+	"github.com/chaosblade-io/chaosblade-exec-os/exec/category"
+	"github.com/chaosblade-io/chaosblade-spec-go/log"
+	"github.com/chaosblade-io/chaosblade-spec-go/spec"
+	"strconv"
+	"strings"
+)
+
+const priorityAction = "process-priority"
+
+// the scheduling policies of /proc/$PID/stat and the chrt options
+var schedPolicies = map[int]string{
+	0: "--other",
+	1: "--fifo",
+	2: "--rr",
+	3: "--batch",
+	5: "--idle",
+}
+
+// the io scheduling classes of ionice
+var ioClasses = map[string]int{
+	"none":        0,
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+type PriorityActionCommandSpec struct {
+	spec.BaseExpActionCommandSpec
+}
+
+func NewPriorityActionCommandSpec() spec.ExpActionCommandSpec {
+	return &PriorityActionCommandSpec{
+		spec.BaseExpActionCommandSpec{
+			ActionMatchers: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "process",
+					Desc: "Process name",
+				},
+				&spec.ExpFlag{
+					Name: "process-cmd",
+					Desc: "Process name in command",
+				},
+				&spec.ExpFlag{
+					Name: "count",
+					Desc: "Limit count, 0 means unlimited",
+				},
+				&spec.ExpFlag{
+					Name: "local-port",
+					Desc: "Local service ports. Separate multiple ports with commas (,) or connector representing ranges, for example: 80,8000-8080",
+				},
+				&spec.ExpFlag{
+					Name: "exclude-process",
+					Desc: "Exclude process",
+				},
+				&spec.ExpFlag{
+					Name: "pid",
+					Desc: "pid",
+				},
+			},
+			ActionFlags: []spec.ExpFlagSpec{
+				&spec.ExpFlag{
+					Name: "nice",
+					Desc: "The nice value (-20-19) of the process",
+				},
+				&spec.ExpFlag{
+					Name: "ionice-class",
+					Desc: "The io scheduling class of the process, idle, best-effort or realtime",
+				},
+				&spec.ExpFlag{
+					Name: "ionice-level",
+					Desc: "The io priority (0-7) of the best-effort and realtime class, default value is 7",
+				},
+				&spec.ExpFlag{
+					Name: "policy",
+					Desc: "The scheduling policy of the process, idle or batch",
+				},
+			},
+			ActionExecutor: &PriorityExecutor{},
+			ActionExample: `
+# Renice the process that contains the "SimpleHTTPServer" keyword to 19
+blade create process priority --process SimpleHTTPServer --nice 19
+
+# Move the io of the Java process to the idle class
+blade create process priority --process-cmd java --ionice-class idle
+
+# Switch the process listening on port 8080 to SCHED_IDLE
+blade create process priority --local-port 8080 --policy idle`,
+			ActionCategories: []string{category.SystemProcess},
+		},
+	}
+}
+
+func (*PriorityActionCommandSpec) Name() string {
+	return "priority"
+}
+
+func (*PriorityActionCommandSpec) Aliases() []string {
+	return []string{}
+}
+
+func (*PriorityActionCommandSpec) ShortDesc() string {
+	return "process scheduling degradation"
+}
+
+func (p *PriorityActionCommandSpec) LongDesc() string {
+	if p.ActionLongDesc != "" {
+		return p.ActionLongDesc
+	}
+	return "Renice the process, change the io scheduling class or switch the process to SCHED_IDLE or SCHED_BATCH, " +
+		"all threads of the process are changed. The original values are restored when the experiment is destroyed"
+}
+
+type PriorityExecutor struct {
+	channel spec.Channel
+}
+
+func (pe *PriorityExecutor) Name() string {
+	return "priority"
+}
+
+func (pe *PriorityExecutor) SetChannel(channel spec.Channel) {
+	pe.channel = channel
+}
+
+// priorityState is the original priority of the process, it is read from the main thread
+type priorityState struct {
+	Pid        string `json:"pid"`
+	StartTime  uint64 `json:"startTime"`
+	Nice       int    `json:"nice"`
+	Policy     int    `json:"policy"`
+	RtPriority int    `json:"rtPriority"`
+	IoClass    int    `json:"ioClass"`
+	IoLevel    int    `json:"ioLevel"`
+}
+
+// priorityRecord records which priorities are changed and the original values of each pid
+type priorityRecord struct {
+	Nice   bool            `json:"nice"`
+	Io     bool            `json:"io"`
+	Policy bool            `json:"policy"`
+	States []priorityState `json:"states"`
+}
+
+// priorityOptions is the priority to apply, only the flags specified are applied
+type priorityOptions struct {
+	setNice    bool
+	nice       int
+	setIo      bool
+	ioClass    int
+	ioLevel    int
+	setPolicy  bool
+	policy     int
+	rtPriority int
+}
+
+func (pe *PriorityExecutor) Exec(uid string, ctx context.Context, model *spec.ExpModel) *spec.Response {
+	if pe.channel == nil {
+		return spec.ResponseFailWithFlags(spec.ChannelNil)
+	}
+	if _, ok := spec.IsDestroy(ctx); ok {
+		return pe.stop(ctx, uid)
+	}
+
+	var opts priorityOptions
+	commands := []string{"cat", "ls", "test"}
+	if niceStr := model.ActionFlags["nice"]; niceStr != "" {
+		nice, err := strconv.Atoi(niceStr)
+		if err != nil || nice < -20 || nice > 19 {
+			log.Errorf(ctx, "`%s`: nice is illegal, it must be an integer between -20 and 19", niceStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "nice", niceStr, "it must be an integer between -20 and 19")
+		}
+		opts.setNice, opts.nice = true, nice
+		commands = append(commands, "renice")
+	}
+	if ioClassStr := model.ActionFlags["ionice-class"]; ioClassStr != "" {
+		ioClass, ok := ioClasses[ioClassStr]
+		if !ok || ioClass == 0 {
+			log.Errorf(ctx, "`%s`: ionice-class is illegal, it must be idle, best-effort or realtime", ioClassStr)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "ionice-class", ioClassStr, "it must be idle, best-effort or realtime")
+		}
+		opts.setIo, opts.ioClass, opts.ioLevel = true, ioClass, 7
+		if ioLevelStr := model.ActionFlags["ionice-level"]; ioLevelStr != "" {
+			ioLevel, err := strconv.Atoi(ioLevelStr)
+			if err != nil || ioLevel < 0 || ioLevel > 7 {
+				log.Errorf(ctx, "`%s`: ionice-level is illegal, it must be an integer between 0 and 7", ioLevelStr)
+				return spec.ResponseFailWithFlags(spec.ParameterIllegal, "ionice-level", ioLevelStr, "it must be an integer between 0 and 7")
+			}
+			opts.ioLevel = ioLevel
+		}
+		commands = append(commands, "ionice")
+	}
+	if policy := model.ActionFlags["policy"]; policy != "" {
+		switch policy {
+		case "idle":
+			opts.policy = 5
+		case "batch":
+			opts.policy = 3
+		default:
+			log.Errorf(ctx, "`%s`: policy is illegal, it must be idle or batch", policy)
+			return spec.ResponseFailWithFlags(spec.ParameterIllegal, "policy", policy, "it must be idle or batch")
+		}
+		opts.setPolicy = true
+		commands = append(commands, "chrt")
+	}
+	if !opts.setNice && !opts.setIo && !opts.setPolicy {
+		log.Errorf(ctx, "nice, ionice-class or policy is required")
+		return spec.ResponseFailWithFlags(spec.ParameterLess, "nice|ionice-class|policy")
+	}
+	if response, ok := pe.channel.IsAllCommandsAvailable(ctx, commands); !ok {
+		return response
+	}
+
+	resp := getPids(ctx, pe.channel, model, uid)
+	if !resp.Success {
+		return resp
+	}
+	pids, ok := resp.Result.(string)
+	if !ok || pids == "" {
+		// the process is not found and ignore-not-found is specified
+		return resp
+	}
+	return pe.start(ctx, uid, strings.Fields(pids), opts)
+}
+
+func (pe *PriorityExecutor) start(ctx context.Context, uid string, pids []string, opts priorityOptions) *spec.Response {
+	record := &priorityRecord{Nice: opts.setNice, Io: opts.setIo, Policy: opts.setPolicy}
+	for _, pid := range pids {
+		state, err := pe.readPriority(ctx, pid, opts.setIo)
+		if err != nil {
+			log.Errorf(ctx, "get the priority of pid %s failed, %v", pid, err)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "get the priority of pid "+pid, err)
+		}
+		if opts.setPolicy {
+			if _, ok := schedPolicies[state.Policy]; !ok {
+				log.Errorf(ctx, "the scheduling policy %d of pid %s cannot be restored", state.Policy, pid)
+				return spec.ResponseFailWithFlags(spec.ParameterIllegal, "pid", pid, fmt.Sprintf("the scheduling policy %d is not supported", state.Policy))
+			}
+		}
+		record.States = append(record.States, *state)
+	}
+	if err := exec.SaveRecord(priorityAction, uid, record); err != nil {
+		log.Errorf(ctx, "save the original priority failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "save the original priority", err)
+	}
+
+	for i, state := range record.States {
+		if err := pe.apply(ctx, state.Pid, opts); err != nil {
+			log.Errorf(ctx, "change the priority of pid %s failed, %v", state.Pid, err)
+			pe.restore(ctx, &priorityRecord{Nice: record.Nice, Io: record.Io, Policy: record.Policy, States: record.States[:i+1]})
+			exec.RemoveRecord(priorityAction, uid)
+			return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "change the priority of pid "+state.Pid, err)
+		}
+		log.Infof(ctx, "change the priority of pid %s, %+v", state.Pid, opts)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+func (pe *PriorityExecutor) stop(ctx context.Context, uid string) *spec.Response {
+	var record priorityRecord
+	if err := exec.LoadRecord(priorityAction, uid, &record); err != nil {
+		log.Errorf(ctx, "load the original priority failed, %v", err)
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "load the original priority", err)
+	}
+	if failed := pe.restore(ctx, &record); len(failed) > 0 {
+		return spec.ResponseFailWithFlags(spec.OsCmdExecFailed, "restore the priority of pid "+strings.Join(failed, ","), "see the log for details")
+	}
+	if err := exec.RemoveRecord(priorityAction, uid); err != nil {
+		log.Warnf(ctx, "remove the original priority record failed, %v", err)
+	}
+	return spec.ReturnSuccess(uid)
+}
+
+// restore restores the original priority of the processes, returns the pids failed
+func (pe *PriorityExecutor) restore(ctx context.Context, record *priorityRecord) []string {
+	var failed []string
+	for _, state := range record.States {
+		if alive, err := sameProcess(ctx, pe.channel, state.Pid, state.StartTime); err != nil {
+			log.Errorf(ctx, "check the pid %s failed, %v", state.Pid, err)
+			failed = append(failed, state.Pid)
+			continue
+		} else if !alive {
+			log.Infof(ctx, "the pid %s has exited, skip restoring its priority", state.Pid)
+			continue
+		}
+		opts := priorityOptions{
+			setNice: record.Nice, nice: state.Nice,
+			setIo: record.Io, ioClass: state.IoClass, ioLevel: state.IoLevel,
+			setPolicy: record.Policy, policy: state.Policy, rtPriority: state.RtPriority,
+		}
+		if err := pe.apply(ctx, state.Pid, opts); err != nil {
+			if alive, _ := sameProcess(ctx, pe.channel, state.Pid, state.StartTime); !alive {
+				log.Infof(ctx, "the pid %s has exited, skip restoring its priority", state.Pid)
+				continue
+			}
+			log.Errorf(ctx, "restore the priority of pid %s failed, %v", state.Pid, err)
+			failed = append(failed, state.Pid)
+			continue
+		}
+		log.Infof(ctx, "restore the priority of pid %s, %+v", state.Pid, state)
+	}
+	return failed
+}
+
+// apply changes the priority of all threads of the process
+func (pe *PriorityExecutor) apply(ctx context.Context, pid string, opts priorityOptions) error {
+	response := pe.channel.Run(ctx, "ls", fmt.Sprintf("/proc/%s/task", pid))
+	if !response.Success {
+		return fmt.Errorf("list the threads failed, %s", response.Err)
+	}
+	tids := strings.Join(strings.Fields(response.Result.(string)), " ")
+	if opts.setPolicy {
+		// the priority is only used by fifo and rr, it is 0 for the others
+		response = pe.channel.Run(ctx, "chrt", fmt.Sprintf("-a %s -p %d %s", schedPolicies[opts.policy], opts.rtPriority, pid))
+		if !response.Success {
+			return fmt.Errorf("chrt failed, %s", response.Err)
+		}
+	}
+	if opts.setNice {
+		response = pe.channel.Run(ctx, "renice", fmt.Sprintf("-n %d -p %s", opts.nice, tids))
+		if !response.Success {
+			return fmt.Errorf("renice failed, %s", response.Err)
+		}
+	}
+	if opts.setIo {
+		args := fmt.Sprintf("-c %d -p %s", opts.ioClass, tids)
+		if opts.ioClass == 1 || opts.ioClass == 2 {
+			args = fmt.Sprintf("-c %d -n %d -p %s", opts.ioClass, opts.ioLevel, tids)
+		}
+		response = pe.channel.Run(ctx, "ionice", args)
+		if !response.Success {
+			return fmt.Errorf("ionice failed, %s", response.Err)
+		}
+	}
+	return nil
+}
+
+// readPriority reads the nice value and the scheduling policy from /proc/$PID/stat,
+// and the io scheduling class from ionice
+func (pe *PriorityExecutor) readPriority(ctx context.Context, pid string, io bool) (*priorityState, error) {
+	response := pe.channel.Run(ctx, "cat", fmt.Sprintf("/proc/%s/stat", pid))
+	if !response.Success {
+		return nil, fmt.Errorf("read stat failed, %s", response.Err)
+	}
+	state, err := parseProcStat(response.Result.(string))
+	if err != nil {
+		return nil, err
+	}
+	state.Pid = pid
+	if io {
+		response = pe.channel.Run(ctx, "ionice", "-p "+pid)
+		if !response.Success {
+			return nil, fmt.Errorf("ionice failed, %s", response.Err)
+		}
+		if state.IoClass, state.IoLevel, err = parseIonice(response.Result.(string)); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// parseProcStat parses the nice (19), starttime (22), rt_priority (40) and policy (41) fields of
+// /proc/$PID/stat, the fields are counted after the command name which may contain spaces
+func parseProcStat(stat string) (*priorityState, error) {
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat: %q", stat)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 39 {
+		return nil, fmt.Errorf("invalid stat: %q", stat)
+	}
+	state := &priorityState{}
+	var err error
+	if state.Nice, err = strconv.Atoi(fields[16]); err != nil {
+		return nil, err
+	}
+	if state.StartTime, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return nil, err
+	}
+	if state.RtPriority, err = strconv.Atoi(fields[37]); err != nil {
+		return nil, err
+	}
+	if state.Policy, err = strconv.Atoi(fields[38]); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// parseIonice parses the output of `ionice -p`, such as `best-effort: prio 4` or `idle`
+func parseIonice(output string) (int, int, error) {
+	output = strings.TrimSpace(output)
+	name, prio := output, ""
+	if i := strings.Index(output, ":"); i >= 0 {
+		name, prio = output[:i], strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(output[i+1:]), "prio"))
+	}
+	class, ok := ioClasses[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown io scheduling class: %q", output)
+	}
+	if prio == "" {
+		return class, 0, nil
+	}
+	level, err := strconv.Atoi(prio)
+	return class, level, err
+}
+
+// readStartTime returns the start time of the process from /proc/$PID/stat, the pid
+// and the start time identify the process even if the pid is recycled. It returns
+// false if the process has exited.
+func readStartTime(ctx context.Context, cl spec.Channel, pid string) (uint64, bool, error) {
+	response := cl.Run(ctx, "cat", fmt.Sprintf("/proc/%s/stat", pid))
+	if !response.Success {
+		if !cl.Run(ctx, "test", fmt.Sprintf("-d /proc/%s", pid)).Success {
+			return 0, false, nil
+		}
+		return 0, true, fmt.Errorf("read stat failed, %s", response.Err)
+	}
+	state, err := parseProcStat(response.Result.(string))
+	if err != nil {
+		return 0, true, err
+	}
+	return state.StartTime, true, nil
+}
+
+// sameProcess returns false if the process has exited or the pid is recycled by another process
+func sameProcess(ctx context.Context, cl spec.Channel, pid string, startTime uint64) (bool, error) {
+	current, alive, err := readStartTime(ctx, cl, pid)
+	if err != nil || !alive {
+		return false, err
+	}
+	return current == startTime, nil
+}
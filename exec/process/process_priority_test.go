@@ -0,0 +1,62 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// the command name contains spaces and a parenthesis
+	stat := "1234 (my (proc) name) S 1 1234 1234 0 -1 4194560 100 0 0 0 5 3 0 0 20 5 1 0 987654 " +
+		"10000000 200 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 2 7 3 0 0 0"
+	state, err := parseProcStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Nice != 5 || state.StartTime != 987654 || state.RtPriority != 7 || state.Policy != 3 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+	if _, err := parseProcStat("1234 (short) S 1"); err == nil {
+		t.Errorf("expected error for a truncated stat")
+	}
+	if _, err := parseProcStat("garbage"); err == nil {
+		t.Errorf("expected error for a stat without the command name")
+	}
+}
+
+func TestParseIonice(t *testing.T) {
+	tests := []struct {
+		output string
+		class  int
+		level  int
+	}{
+		{"best-effort: prio 4\n", 2, 4},
+		{"none: prio 4", 0, 4},
+		{"realtime: prio 0", 1, 0},
+		{"idle\n", 3, 0},
+	}
+	for _, tt := range tests {
+		class, level, err := parseIonice(tt.output)
+		if err != nil || class != tt.class || level != tt.level {
+			t.Errorf("%q: expected %d/%d, got %d/%d, %v", tt.output, tt.class, tt.level, class, level, err)
+		}
+	}
+	if _, _, err := parseIonice("unknown: prio 1"); err == nil {
+		t.Errorf("expected error for an unknown class")
+	}
+}
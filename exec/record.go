@@ -0,0 +1,134 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/chaosblade-io/chaosblade-spec-go/util"
+)
+
+// RecordDir is the directory of the files which record the original states
+// modified by the experiments, the states are restored on destroy. It is
+// owned by the current user and not accessible by others, so that the records
+// cannot be planted or replaced.
+var RecordDir = filepath.Join(util.GetProgramPath(), "records")
+
+func recordFile(action, uid string) string {
+	return filepath.Join(RecordDir, fmt.Sprintf("chaos-%s-%s.json", action, uid))
+}
+
+// checkOwner returns error if the file is not owned by the current user
+func checkOwner(path string, info os.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is not owned by the current user", path)
+	}
+	return nil
+}
+
+// ensureRecordDir creates the record directory with 0700, an existing
+// directory must be a real directory owned by the current user
+func ensureRecordDir() error {
+	if err := os.MkdirAll(RecordDir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(RecordDir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", RecordDir)
+	}
+	if err := checkOwner(RecordDir, info); err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return os.Chmod(RecordDir, 0700)
+	}
+	return nil
+}
+
+// SaveRecord saves the original state of the experiment uid
+func SaveRecord(action, uid string, state interface{}) error {
+	if uid == "" {
+		return fmt.Errorf("the uid of the %s experiment is empty", action)
+	}
+	if err := ensureRecordDir(); err != nil {
+		return fmt.Errorf("prepare the record directory failed, %v", err)
+	}
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	file := recordFile(action, uid)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("the %s experiment %s is already running", action, uid)
+		}
+		return err
+	}
+	if _, err := f.Write(bytes); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+// LoadRecord loads the original state of the experiment uid, the record must
+// be a regular file owned by the current user
+func LoadRecord(action, uid string, state interface{}) error {
+	if uid == "" {
+		return fmt.Errorf("the uid of the %s experiment is required to restore", action)
+	}
+	file := recordFile(action, uid)
+	f, err := os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("the original state of the %s experiment %s not found, %w", action, uid, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", file)
+	}
+	if err := checkOwner(file, info); err != nil {
+		return err
+	}
+	bytes, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, state)
+}
+
+// RemoveRecord removes the original state of the experiment uid
+func RemoveRecord(action, uid string) error {
+	err := os.Remove(recordFile(action, uid))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
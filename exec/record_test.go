@@ -0,0 +1,81 @@
+/*
+ * Copyright 1999-2020 Alibaba Group Holding Ltd.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package exec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func useRecordDir(t *testing.T) {
+	dir := RecordDir
+	RecordDir = filepath.Join(t.TempDir(), "records")
+	t.Cleanup(func() { RecordDir = dir })
+}
+
+func TestRecord(t *testing.T) {
+	useRecordDir(t)
+	type state struct{ Value string }
+	if err := SaveRecord("test", "uid1", &state{Value: "max"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveRecord("test", "uid1", &state{}); err == nil {
+		t.Errorf("expected error for a running experiment")
+	}
+	var loaded state
+	if err := LoadRecord("test", "uid1", &loaded); err != nil || loaded.Value != "max" {
+		t.Errorf("expected max, got %q, %v", loaded.Value, err)
+	}
+	if info, err := os.Stat(RecordDir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("expected the record directory mode 0700, got %v, %v", info.Mode(), err)
+	}
+	if err := RemoveRecord("test", "uid1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveRecord("test", "uid1"); err != nil {
+		t.Errorf("expected no error for a removed record, got %v", err)
+	}
+	if err := LoadRecord("test", "uid1", &loaded); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error for a removed record, got %v", err)
+	}
+}
+
+func TestRecordSymlink(t *testing.T) {
+	useRecordDir(t)
+	if err := ensureRecordDir(); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.Symlink(target, recordFile("test", "uid2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveRecord("test", "uid2", "state"); err == nil {
+		t.Errorf("expected error for a planted symlink")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected the symlink target not created, got %v", err)
+	}
+	if err := os.WriteFile(target, []byte(`"state"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var state string
+	if err := LoadRecord("test", "uid2", &state); err == nil {
+		t.Errorf("expected error for loading a symlink")
+	}
+}